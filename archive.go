@@ -0,0 +1,339 @@
+package webloop
+
+import (
+	"encoding/base64"
+	"fmt"
+	"html"
+	"io"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gotk3/gotk3/glib"
+)
+
+// ArchiveFormat is a format in which SaveArchive saves a page.
+type ArchiveFormat string
+
+const (
+	// ArchiveMHTML is a MIME multipart archive (as saved by browsers) of the
+	// page's DOM and its resources, generated by WebKit.
+	ArchiveMHTML ArchiveFormat = "mhtml"
+
+	// ArchiveSingleFile is a single HTML file of the page's DOM with its
+	// stylesheets inlined and its images, fonts and other resources
+	// referenced by its HTML and CSS embedded as data URIs. Only resources
+	// that the page loaded are embedded; other URLs are left unchanged. Its
+	// <script> tags are disabled (as with StaticRenderer.RemoveScripts), so
+	// that the archived page doesn't change when it is opened.
+	ArchiveSingleFile ArchiveFormat = "html"
+)
+
+// maxArchiveResources is the maximum number of a page's resources that a View
+// keeps for SaveArchive.
+const maxArchiveResources = 1000
+
+// asyncPollInterval is how often SaveArchive checks whether WebKit has
+// finished saving the page or getting a resource's data.
+const asyncPollInterval = 10 * time.Millisecond
+
+// SaveArchive writes the page currently loaded in the view, as rendered, to w
+// in the given format.
+func (v *View) SaveArchive(w io.Writer, format ArchiveFormat) error {
+	switch format {
+	case ArchiveMHTML:
+		var d *asyncData
+		if err := v.do(func() { d = saveMHTML(v.WebView) }); err != nil {
+			return err
+		}
+		data, err := v.waitAsync(d)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+
+	case ArchiveSingleFile:
+		content, err := v.Render(FormatHTML)
+		if err != nil {
+			return err
+		}
+		resources, err := v.archiveResources()
+		if err != nil {
+			return err
+		}
+		_, err = io.WriteString(w, inlineResources(content, v.URI(), resources))
+		return err
+	}
+	return fmt.Errorf("unknown archive format %q", format)
+}
+
+// pageResource is a WebKitWebResource that a page loaded.
+type pageResource struct {
+	resource *glib.Object
+
+	// requestURI is the URI that the page requested, which differs from
+	// the resource's URI if the request was redirected.
+	requestURI string
+}
+
+// archiveResource is a resource that a page loaded.
+type archiveResource struct {
+	mimeType string
+	data     []byte
+}
+
+// archiveResources returns the data of the resources that the current page
+// loaded, keyed by URI and, for redirected resources, also by the URI that the
+// page requested (which its HTML and CSS refer to). Resources whose data isn't
+// available are omitted.
+func (v *View) archiveResources() (map[string]archiveResource, error) {
+	type pending struct {
+		uri, requestURI, mimeType string
+		data                      *asyncData
+	}
+	var all []pending
+	err := v.do(func() {
+		for _, res := range v.resources {
+			uri, err := res.resource.GetProperty("uri")
+			if err != nil {
+				continue
+			}
+			p := pending{requestURI: res.requestURI, mimeType: resourceMIMEType(res.resource), data: resourceData(res.resource)}
+			p.uri, _ = uri.(string)
+			all = append(all, p)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resources := map[string]archiveResource{}
+	for _, p := range all {
+		data, err := v.waitAsync(p.data)
+		if err == ErrViewClosed || err == ErrWebProcessCrashed {
+			return nil, err
+		}
+		if err != nil {
+			continue
+		}
+		res := archiveResource{mimeType: p.mimeType, data: data}
+		if p.uri != "" {
+			resources[p.uri] = res
+		}
+		// A resource loaded at a URI takes precedence over one
+		// redirected from it.
+		if _, ok := resources[p.requestURI]; !ok && p.requestURI != "" {
+			resources[p.requestURI] = res
+		}
+	}
+	return resources, nil
+}
+
+// waitAsync waits for the asynchronous operation d to finish and returns its
+// result.
+func (v *View) waitAsync(d *asyncData) ([]byte, error) {
+	for {
+		var (
+			done bool
+			data []byte
+			err  error
+		)
+		// If the view is closed or its web process crashes first, d is
+		// never freed, because WebKit may still finish the operation.
+		if err := v.do(func() {
+			if done = d.done(); done {
+				data, err = d.result()
+			}
+		}); err != nil {
+			return nil, err
+		}
+		if done {
+			return data, err
+		}
+		select {
+		case <-time.After(asyncPollInterval):
+		case <-v.crashed:
+			return nil, ErrWebProcessCrashed
+		}
+	}
+}
+
+var (
+	// htmlTag matches an HTML start tag, with its name in group 1.
+	htmlTag = regexp.MustCompile(`(?s)<([a-zA-Z][a-zA-Z0-9-]*)\b[^>]*>`)
+
+	// htmlAttr matches an attribute in a start tag, with its name in group
+	// 1 and its value in group 2, 3 or 4 (depending on how it is quoted).
+	htmlAttr = regexp.MustCompile(`\s([a-zA-Z_:][-a-zA-Z0-9_:.]*)(?:\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'=<>` + "`" + `]+)))?`)
+
+	// baseTag matches a <base> tag.
+	baseTag = regexp.MustCompile(`(?is)<base\b[^>]*>`)
+
+	// styleElement matches a <style> element, with its content in group 2.
+	styleElement = regexp.MustCompile(`(?is)(<style\b[^>]*>)(.*?)(</style>)`)
+
+	// cssURL matches a url() in CSS, with the URL in group 1, 2 or 3, or an
+	// @import of a quoted URL, with the URL in group 4 or 5.
+	cssURL = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)\s]*))\s*\)|@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// inlineResources returns the page content (its HTML) with its stylesheets
+// inlined, the other resources it references embedded as data URIs and its
+// scripts disabled. Relative URLs are resolved against baseURL (or the page's
+// <base href>). Only the resources in resources are inlined.
+func inlineResources(content, baseURL string, resources map[string]archiveResource) string {
+	base, _ := url.Parse(baseURL)
+	if m := baseTag.FindString(content); m != "" && base != nil {
+		for _, a := range htmlAttr.FindAllStringSubmatch(m, -1) {
+			if strings.EqualFold(a[1], "href") {
+				if u, err := base.Parse(html.UnescapeString(a[2] + a[3] + a[4])); err == nil {
+					base = u
+				}
+			}
+		}
+	}
+	in := &inliner{resources: resources}
+
+	// Inline the <style> elements first, so that their content isn't
+	// mistaken for tags.
+	content = styleElement.ReplaceAllStringFunc(content, func(m string) string {
+		parts := styleElement.FindStringSubmatch(m)
+		return parts[1] + in.css(parts[2], base, 0) + parts[3]
+	})
+	content = htmlTag.ReplaceAllStringFunc(content, func(tag string) string {
+		return in.tag(tag, base)
+	})
+	return strings.Replace(content, "<script", `<script type="text/disabled"`, -1)
+}
+
+// inliner inlines resources in HTML and CSS.
+type inliner struct {
+	resources map[string]archiveResource
+}
+
+// maxCSSImportDepth is how deeply inliner follows stylesheets' @imports.
+const maxCSSImportDepth = 5
+
+// resource returns the resource at ref, resolved against base, if the page
+// loaded it.
+func (in *inliner) resource(ref string, base *url.URL) (archiveResource, *url.URL, bool) {
+	if base == nil || ref == "" || strings.HasPrefix(ref, "data:") {
+		return archiveResource{}, nil, false
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return archiveResource{}, nil, false
+	}
+	u.Fragment = ""
+	res, ok := in.resources[u.String()]
+	return res, u, ok
+}
+
+// dataURI returns a data URI for the resource at ref (resolved against base),
+// with the resources in stylesheets inlined, or "" if the page didn't load it.
+func (in *inliner) dataURI(ref string, base *url.URL, depth int) string {
+	res, u, ok := in.resource(ref, base)
+	if !ok {
+		return ""
+	}
+	data := res.data
+	if strings.HasPrefix(res.mimeType, "text/css") {
+		if depth >= maxCSSImportDepth {
+			return ""
+		}
+		data = []byte(in.css(string(data), u, depth+1))
+	}
+	mimeType := res.mimeType
+	if mimeType == "" {
+		mimeType = "application/octet-stream"
+	}
+	return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
+
+// css returns the stylesheet css, whose URL is base, with the resources it
+// references embedded as data URIs.
+func (in *inliner) css(css string, base *url.URL, depth int) string {
+	return cssURL.ReplaceAllStringFunc(css, func(m string) string {
+		g := cssURL.FindStringSubmatch(m)
+		if uri := in.dataURI(g[1]+g[2]+g[3]+g[4]+g[5], base, depth); uri != "" {
+			if strings.HasPrefix(m, "@") {
+				return `@import url("` + uri + `")`
+			}
+			return `url("` + uri + `")`
+		}
+		return m
+	})
+}
+
+// tag returns the HTML start tag with the resources that its attributes
+// reference embedded as data URIs (except for links to other pages and
+// scripts). Stylesheet <link> tags are replaced with <style> elements.
+func (in *inliner) tag(tag string, base *url.URL) string {
+	tagName := strings.ToLower(htmlTag.FindStringSubmatch(tag)[1])
+	if tagName == "script" {
+		// Scripts are disabled, so there's no need to embed them.
+		return tag
+	}
+	attrs := map[string]string{}
+	for _, a := range htmlAttr.FindAllStringSubmatch(tag, -1) {
+		attrs[strings.ToLower(a[1])] = html.UnescapeString(a[2] + a[3] + a[4])
+	}
+
+	if tagName == "link" && strings.Contains(" "+strings.ToLower(attrs["rel"])+" ", " stylesheet ") {
+		res, u, ok := in.resource(attrs["href"], base)
+		if !ok {
+			return tag
+		}
+		style := "<style"
+		if media, ok := attrs["media"]; ok {
+			style += ` media="` + html.EscapeString(media) + `"`
+		}
+		return style + ">" + in.css(string(res.data), u, 0) + "</style>"
+	}
+
+	return htmlAttr.ReplaceAllStringFunc(tag, func(attr string) string {
+		a := htmlAttr.FindStringSubmatch(attr)
+		name, value := strings.ToLower(a[1]), html.UnescapeString(a[2]+a[3]+a[4])
+		var replaced string
+		switch {
+		case name == "src" || name == "poster" || (name == "href" && tagName == "link"):
+			replaced = in.dataURI(value, base, 0)
+		case name == "srcset":
+			replaced = in.srcset(value, base)
+		case name == "style":
+			if css := in.css(value, base, 0); css != value {
+				replaced = css
+			}
+		}
+		if replaced == "" {
+			return attr
+		}
+		return attr[:len(attr)-len(strings.TrimLeft(attr, " \t\r\n\f"))] + a[1] + `="` + html.EscapeString(replaced) + `"`
+	})
+}
+
+// srcset returns the srcset attribute value with the image candidates that the
+// page loaded embedded as data URIs, or "" if it loaded none of them.
+func (in *inliner) srcset(srcset string, base *url.URL) string {
+	var (
+		candidates []string
+		inlined    bool
+	)
+	for _, c := range strings.Split(srcset, ",") {
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		if uri := in.dataURI(fields[0], base, 0); uri != "" {
+			fields[0] = uri
+			inlined = true
+		}
+		candidates = append(candidates, strings.Join(fields, " "))
+	}
+	if !inlined {
+		return ""
+	}
+	return strings.Join(candidates, ", ")
+}
@@ -0,0 +1,108 @@
+package webloop
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestInlineResources(t *testing.T) {
+	dataURI := func(mimeType, data string) string {
+		return "data:" + mimeType + ";base64," + base64.StdEncoding.EncodeToString([]byte(data))
+	}
+	resources := map[string]archiveResource{
+		"http://example.com/a.png":          {mimeType: "image/png", data: []byte("A")},
+		"http://example.com/b.png?x=1&y=2":  {mimeType: "image/png", data: []byte("B")},
+		"http://example.com/css/site.css":   {mimeType: "text/css", data: []byte(`@font-face { src: url(font.woff2) }`)},
+		"http://example.com/css/font.woff2": {mimeType: "font/woff2", data: []byte("F")},
+		"http://example.com/app.js":         {mimeType: "application/javascript", data: []byte("J")},
+	}
+	fontCSS := `@font-face { src: url("` + dataURI("font/woff2", "F") + `") }`
+
+	tests := []struct {
+		html string
+		want string
+	}{
+		{
+			html: `<img src="a.png" alt="a"><img src="/b.png?x=1&amp;y=2"><img src="missing.png">`,
+			want: `<img src="` + dataURI("image/png", "A") + `" alt="a"><img src="` + dataURI("image/png", "B") + `"><img src="missing.png">`,
+		},
+		{
+			html: `<link rel="stylesheet" href="css/site.css" media="print">`,
+			want: `<style media="print">` + fontCSS + `</style>`,
+		},
+		{
+			html: `<style>body { background: url('a.png') }</style><div style="background: url(a.png)">`,
+			want: `<style>body { background: url("` + dataURI("image/png", "A") + `") }</style><div style="background: url(&#34;` + dataURI("image/png", "A") + `&#34;)">`,
+		},
+		{
+			html: `<style>@import "css/site.css";</style>`,
+			want: `<style>@import url("` + dataURI("text/css", fontCSS) + `");</style>`,
+		},
+		{
+			html: `<img srcset="a.png 1x, c.png 2x"><a href="a.png">a</a>`,
+			want: `<img srcset="` + dataURI("image/png", "A") + ` 1x, c.png 2x"><a href="a.png">a</a>`,
+		},
+		{
+			html: `<script src="app.js"></script>`,
+			want: `<script type="text/disabled" src="app.js"></script>`,
+		},
+		{
+			html: `<base href="/css/"><link rel="icon" href="font.woff2">`,
+			want: `<base href="/css/"><link rel="icon" href="` + dataURI("font/woff2", "F") + `">`,
+		},
+	}
+	for _, test := range tests {
+		if got := inlineResources(test.html, "http://example.com/page", resources); got != test.want {
+			t.Errorf("%s:\nwant %s\ngot  %s", test.html, test.want, got)
+		}
+	}
+}
+
+func TestView_SaveArchive(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<html><head><link rel="stylesheet" href="/style.css"><link rel="stylesheet" href="/old.css"></head><body><p>Archived</p></body></html>`))
+	})
+	mux.HandleFunc("/style.css", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte(`p { color: red }`))
+	})
+	mux.Handle("/old.css", http.RedirectHandler("/new.css", http.StatusMovedPermanently))
+	mux.HandleFunc("/new.css", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte(`h1 { color: blue }`))
+	})
+
+	view := ctx.NewView()
+	defer view.Close()
+	view.Open(server.URL)
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	var mhtml bytes.Buffer
+	if err := view.SaveArchive(&mhtml, ArchiveMHTML); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(mhtml.String(), "multipart/related") || !strings.Contains(mhtml.String(), "Archived") {
+		t.Errorf("want MHTML archive, got %q", mhtml.String())
+	}
+
+	var single bytes.Buffer
+	if err := view.SaveArchive(&single, ArchiveSingleFile); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<style>p { color: red }</style>`, `<style>h1 { color: blue }</style>`} {
+		if !strings.Contains(single.String(), want) {
+			t.Errorf("want single-file archive containing %q, got %q", want, single.String())
+		}
+	}
+
+	if err := view.SaveArchive(&single, "pdf"); err == nil {
+		t.Error("want error for unknown archive format")
+	}
+}
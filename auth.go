@@ -0,0 +1,85 @@
+package webloop
+
+import (
+	"net/url"
+	"strconv"
+
+	"github.com/gotk3/gotk3/glib"
+)
+
+// Credentials are a username and password for HTTP authentication.
+type Credentials struct {
+	Username, Password string
+}
+
+// credentials returns the credentials for the server at host and port, and
+// whether there are any. Credentials keyed by "host:port" take precedence over
+// those keyed by host alone.
+func (c *Context) credentials(host string, port int) (Credentials, bool) {
+	if cred, ok := c.Credentials[host+":"+strconv.Itoa(port)]; ok {
+		return cred, true
+	}
+	cred, ok := c.Credentials[host]
+	return cred, ok
+}
+
+// authenticate is called on the GTK+ thread when a server requests
+// authentication in req. It returns whether it handled the request.
+func (c *Context) authenticate(req *glib.Object) bool {
+	host, port, clientCert, retry := authenticationRequest(req)
+	if clientCert {
+		if c.ClientCertFile == "" {
+			return false
+		}
+		if retry {
+			cancelAuthentication(req)
+			return true
+		}
+		if err := authenticateWithCertificate(req, c.ClientCertFile, c.ClientKeyFile); err != nil {
+			cancelAuthentication(req)
+		}
+		return true
+	}
+
+	cred, ok := c.credentials(host, port)
+	if !ok {
+		return false
+	}
+	if retry {
+		// The credentials were rejected. Fail the load instead of
+		// trying them again.
+		cancelAuthentication(req)
+		return true
+	}
+	authenticateWithPassword(req, cred.Username, cred.Password)
+	return true
+}
+
+// trustsCertificate reports whether cert, which failed TLS verification, should
+// be accepted for host.
+func (c *Context) trustsCertificate(host string, cert *glib.Object) bool {
+	for _, h := range c.InsecureHosts {
+		if h == host {
+			return true
+		}
+	}
+	if c.CAFile != "" {
+		ok, _ := verifyCertificate(cert, host, c.CAFile)
+		return ok
+	}
+	return false
+}
+
+// tlsErrors is called on the GTK+ thread when v fails to load uri because its
+// TLS certificate, cert, failed verification. If the context trusts cert for
+// uri's host, tlsErrors allows it and loads uri again. It returns whether it
+// handled the failure.
+func (c *Context) tlsErrors(v *View, uri string, cert *glib.Object) bool {
+	u, err := url.Parse(uri)
+	if err != nil || !c.trustsCertificate(u.Hostname(), cert) {
+		return false
+	}
+	allowTLSCertificate(v.WebView, cert, u.Hostname())
+	v.retryLoad(uri)
+	return true
+}
@@ -0,0 +1,69 @@
+package webloop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestContext_credentials(t *testing.T) {
+	c := &Context{Credentials: map[string]Credentials{
+		"example.com":      {Username: "a", Password: "pa"},
+		"example.com:8443": {Username: "b", Password: "pb"},
+	}}
+	tests := []struct {
+		host     string
+		port     int
+		want     Credentials
+		wantNone bool
+	}{
+		{host: "example.com", port: 443, want: Credentials{"a", "pa"}},
+		{host: "example.com", port: 8443, want: Credentials{"b", "pb"}},
+		{host: "other.example.com", port: 443, wantNone: true},
+	}
+	for _, test := range tests {
+		cred, ok := c.credentials(test.host, test.port)
+		if ok == test.wantNone {
+			t.Errorf("%s:%d: want ok == %v, got %v", test.host, test.port, !test.wantNone, ok)
+			continue
+		}
+		if cred != test.want {
+			t.Errorf("%s:%d: want %+v, got %+v", test.host, test.port, test.want, cred)
+		}
+	}
+}
+
+func TestContext_trustsCertificate_insecureHosts(t *testing.T) {
+	c := &Context{InsecureHosts: []string{"staging.example.com"}}
+	if !c.trustsCertificate("staging.example.com", nil) {
+		t.Error("want certificate for insecure host to be trusted")
+	}
+	if c.trustsCertificate("example.com", nil) {
+		t.Error("want certificate for other host not to be trusted")
+	}
+}
+
+func TestView_OpenWithHeader_insecureHostRetry(t *testing.T) {
+	gotHeader := make(chan string, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case gotHeader <- r.Header.Get("X-Test"):
+		default:
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	// The first request fails because the test server's certificate isn't
+	// trusted, and is retried once the certificate is allowed.
+	c := &Context{InsecureHosts: []string{"127.0.0.1"}}
+	view := c.NewView()
+	defer view.Close()
+	view.OpenWithHeader(server.URL, http.Header{"X-Test": {"a"}})
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := <-gotHeader; got != "a" {
+		t.Errorf("want retried request to have X-Test header %q, got %q", "a", got)
+	}
+}
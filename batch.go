@@ -0,0 +1,263 @@
+package webloop
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrNotReady indicates that a page did not become ready within the wait
+// timeout.
+var ErrNotReady = errors.New("page did not become ready within timeout")
+
+// Job is a page to render in a Batch.
+type Job struct {
+	// URL is the URL of the page.
+	URL string
+
+	// ReadyExpression is the JavaScript expression that is true when the
+	// page is ready, as in StaticRenderer. If empty,
+	// DefaultReadyExpression is used. To run the scripts as soon as the page
+	// loads, use "true".
+	ReadyExpression string
+
+	// WaitTimeout is the maximum duration to wait for the page to become
+	// ready. If zero, the Batch's WaitTimeout is used.
+	WaitTimeout time.Duration
+
+	// Scripts are JavaScript expressions to evaluate in the page once it is
+	// ready, keyed by name. Their results are stored in the Result's Values
+	// under the same names. For example, use
+	// "document.documentElement.outerHTML" to get the page's rendered HTML.
+	Scripts map[string]string
+}
+
+// Result is the result of a Job.
+type Result struct {
+	// Job is the job that produced this result.
+	Job *Job
+
+	// Values are the results of the job's Scripts, keyed by name.
+	Values map[string]interface{}
+
+	// Attempts is the number of times the job was attempted.
+	Attempts int
+
+	// Err is the error from the last attempt, or nil if the job succeeded.
+	Err error
+}
+
+// DefaultBatchWorkers is the number of Views that a Batch uses if its Workers
+// is zero.
+const DefaultBatchWorkers = 4
+
+// Batch renders many pages concurrently using a bounded pool of Views.
+type Batch struct {
+	// Context is the WebLoop context to create views in.
+	Context Context
+
+	// Workers is the number of Views to render pages in concurrently. If
+	// zero, DefaultBatchWorkers is used; if negative, 1 is used.
+	Workers int
+
+	// WaitTimeout is the maximum duration to wait for each page to become
+	// ready, for jobs that don't specify their own.
+	WaitTimeout time.Duration
+
+	// Retries is the number of times to retry a failed job.
+	Retries int
+
+	// Backoff is how long to wait before the first retry of a failed job.
+	// The wait doubles for each subsequent retry.
+	Backoff time.Duration
+
+	// Progress, if non-nil, is called after each job finishes (including
+	// all of its retries) with its result and the number of jobs finished so
+	// far. Calls are serialized.
+	Progress func(result *Result, done int)
+
+	// Log is the logger to use for log messages. If nil, there is no log
+	// output.
+	Log *log.Logger
+}
+
+// Run renders the jobs and returns their results in the same order. The same
+// *Job may be given more than once; it is run (and has a result) each time.
+func (b *Batch) Run(jobs []*Job) []*Result {
+	tasks := make(chan batchTask)
+	go func() {
+		for i, job := range jobs {
+			tasks <- batchTask{i: i, job: job}
+		}
+		close(tasks)
+	}()
+
+	results := make([]*Result, len(jobs))
+	for t := range b.run(tasks) {
+		results[t.i] = t.result
+	}
+	return results
+}
+
+// RunChan renders jobs as they are received on jobs, and sends their results
+// on the returned channel in the order they finish. The returned channel is
+// closed after jobs is closed and all of its jobs have finished.
+func (b *Batch) RunChan(jobs <-chan *Job) <-chan *Result {
+	tasks := make(chan batchTask)
+	go func() {
+		for job := range jobs {
+			tasks <- batchTask{job: job}
+		}
+		close(tasks)
+	}()
+
+	results := make(chan *Result)
+	go func() {
+		for t := range b.run(tasks) {
+			results <- t.result
+		}
+		close(results)
+	}()
+	return results
+}
+
+// batchTask is a job to run, along with its index in the jobs passed to Run
+// and its result.
+type batchTask struct {
+	i      int
+	job    *Job
+	result *Result
+}
+
+// run runs the tasks received on tasks in the Batch's views, and sends them
+// with their results set on the returned channel in the order they finish.
+// The returned channel is closed after tasks is closed and all of its tasks
+// have finished.
+func (b *Batch) run(tasks <-chan batchTask) <-chan batchTask {
+	Start()
+
+	workers := b.Workers
+	if workers == 0 {
+		workers = DefaultBatchWorkers
+	} else if workers < 0 {
+		workers = 1
+	}
+
+	finished := make(chan batchTask)
+	var (
+		wg         sync.WaitGroup
+		progressMu sync.Mutex
+		done       int
+	)
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			w := &batchWorker{b: b}
+			defer w.close()
+			for t := range tasks {
+				t.result = w.run(t.job)
+				if b.Progress != nil {
+					progressMu.Lock()
+					done++
+					b.Progress(t.result, done)
+					progressMu.Unlock()
+				}
+				finished <- t
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+	return finished
+}
+
+// batchWorker runs a Batch's jobs one at a time in a view.
+type batchWorker struct {
+	b    *Batch
+	view *View
+}
+
+// run runs job, retrying it if it fails. If the view's web process crashed,
+// the view is replaced before the next attempt.
+func (w *batchWorker) run(job *Job) *Result {
+	b := w.b
+	result := &Result{Job: job}
+	backoff := b.Backoff
+	for {
+		if w.view != nil && w.view.Crashed() {
+			b.logf("Web process crashed; restarting it")
+			w.close()
+		}
+		if w.view == nil {
+			w.view = b.Context.NewView()
+		}
+
+		result.Attempts++
+		result.Values, result.Err = b.attempt(w.view, job)
+		if result.Err == nil || result.Attempts > b.Retries {
+			break
+		}
+		b.logf("Job for URL %s failed (attempt %d), retrying in %s: %s", job.URL, result.Attempts, backoff, result.Err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	if result.Err != nil {
+		b.logf("Job for URL %s failed: %s", job.URL, result.Err)
+	}
+	return result
+}
+
+func (w *batchWorker) close() {
+	if w.view != nil {
+		w.view.Close()
+		w.view = nil
+	}
+}
+
+// attempt loads job's page in view, waits for it to become ready, and
+// evaluates job's scripts.
+func (b *Batch) attempt(view *View, job *Job) (map[string]interface{}, error) {
+	view.Open(job.URL)
+	if err := view.Wait(); err != nil {
+		return nil, err
+	}
+
+	readyExpr := job.ReadyExpression
+	if readyExpr == "" {
+		readyExpr = DefaultReadyExpression
+	}
+	timeout := job.WaitTimeout
+	if timeout == 0 {
+		timeout = b.WaitTimeout
+	}
+	ready, err := waitReady(context.Background(), trace.NewNoopTracerProvider().Tracer(""), view, readyExpr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if !ready {
+		return nil, ErrNotReady
+	}
+
+	values := make(map[string]interface{}, len(job.Scripts))
+	for name, script := range job.Scripts {
+		v, err := view.EvaluateJavaScript(script)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+	return values, nil
+}
+
+func (b *Batch) logf(msg string, v ...interface{}) {
+	if b.Log != nil {
+		b.Log.Printf(msg, v...)
+	}
+}
@@ -0,0 +1,68 @@
+package webloop
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestBatch_Run(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/a", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<html><head><title>a</title></head></html>`))
+	})
+	mux.HandleFunc("/b", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<html><head><title>b</title></head></html>`))
+	})
+	mux.HandleFunc("/never-ready", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<html><head><title>never-ready</title></head></html>`))
+	})
+
+	jobs := []*Job{
+		{URL: server.URL + "/a", ReadyExpression: "true", Scripts: map[string]string{"title": "document.title"}},
+		{URL: server.URL + "/b", ReadyExpression: "true", Scripts: map[string]string{"title": "document.title"}},
+		{URL: server.URL + "/never-ready"},
+	}
+	var progress int
+	b := &Batch{
+		Workers:  2,
+		Retries:  1,
+		Progress: func(_ *Result, done int) { progress = done },
+	}
+	results := b.Run(jobs)
+
+	for i, want := range []string{"a", "b"} {
+		if r := results[i]; r.Err != nil || r.Values["title"] != want {
+			t.Errorf("job %d: want title %q, got %+v", i, want, r)
+		}
+	}
+	if r := results[2]; r.Err != ErrNotReady || r.Attempts != 2 {
+		t.Errorf("want never-ready job to fail with ErrNotReady after 2 attempts, got %+v", r)
+	}
+	if progress != len(jobs) {
+		t.Errorf("want progress == %d, got %d", len(jobs), progress)
+	}
+}
+
+func TestBatch_Run_sameJobTwice(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<html><head><title>a</title></head></html>`))
+	})
+
+	job := &Job{URL: server.URL, ReadyExpression: "true", Scripts: map[string]string{"title": "document.title"}}
+	b := &Batch{Workers: -1}
+	results := b.Run([]*Job{job, job})
+
+	for i, r := range results {
+		if r == nil || r.Job != job || r.Err != nil || r.Values["title"] != "a" {
+			t.Errorf("result %d: want title %q, got %+v", i, "a", r)
+		}
+	}
+	if results[0] == results[1] {
+		t.Error("want a separate result for each run of the job")
+	}
+}
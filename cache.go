@@ -0,0 +1,97 @@
+package webloop
+
+import (
+	"container/list"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultCacheSize is the maximum number of rendered pages that a
+// StaticRenderer caches if its CacheSize is zero.
+const DefaultCacheSize = 1000
+
+// renderCache is an in-memory LRU cache of rendered pages, keyed on their
+// target URL. The zero value is an empty cache.
+type renderCache struct {
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	url     string
+	page    *renderedPage
+	sitemap *SitemapEntry // nil if not collected
+	expires time.Time
+}
+
+// get returns the cached page for url, if there is an unexpired entry for it.
+func (c *renderCache) get(url string) (page *renderedPage, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, present := c.entries[url]
+	if !present {
+		return nil, false
+	}
+	entry := e.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.remove(e)
+		return nil, false
+	}
+	c.lru.MoveToFront(e)
+	return entry.page, true
+}
+
+// add caches page (and its sitemap entry, if non-nil) for url until ttl
+// elapses, evicting the least recently used entries so that at most size
+// entries remain.
+func (c *renderCache) add(url string, page *renderedPage, sitemap *SitemapEntry, ttl time.Duration, size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.lru = list.New()
+		c.entries = make(map[string]*list.Element)
+	}
+	entry := &cacheEntry{url: url, page: page, sitemap: sitemap, expires: time.Now().Add(ttl)}
+	if e, present := c.entries[url]; present {
+		e.Value = entry
+		c.lru.MoveToFront(e)
+	} else {
+		c.entries[url] = c.lru.PushFront(entry)
+	}
+	for c.lru.Len() > size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// sitemapEntries returns the sitemap entries of the unexpired cached pages,
+// sorted by Loc, so that the order is stable as the cache is used. If several
+// pages have the same Loc (such as the HTML and Markdown versions of a page,
+// or pages with the same canonical URL), only the most recently used one's
+// entry is returned.
+func (c *renderCache) sitemapEntries() []*SitemapEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	now := time.Now()
+	var (
+		entries []*SitemapEntry
+		seen    = map[string]bool{}
+	)
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		if entry := e.Value.(*cacheEntry); entry.sitemap != nil && now.Before(entry.expires) && !seen[entry.sitemap.Loc] {
+			seen[entry.sitemap.Loc] = true
+			entries = append(entries, entry.sitemap)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Loc < entries[j].Loc })
+	return entries
+}
+
+func (c *renderCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).url)
+}
@@ -0,0 +1,60 @@
+package webloop
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRenderCache(t *testing.T) {
+	var c renderCache
+	if _, ok := c.get("/a"); ok {
+		t.Error("got entry from empty cache")
+	}
+
+	c.add("/a", &renderedPage{content: "a"}, nil, time.Hour, 2)
+	c.add("/b", &renderedPage{content: "b"}, nil, time.Hour, 2)
+	if page, ok := c.get("/a"); !ok || page.content != "a" {
+		t.Errorf("want /a == %q, got %+v (ok == %v)", "a", page, ok)
+	}
+
+	// /b is now the least recently used entry, so it is evicted.
+	c.add("/c", &renderedPage{content: "c"}, &SitemapEntry{Loc: "http://example.com/c"}, time.Hour, 2)
+	if _, ok := c.get("/b"); ok {
+		t.Error("want /b evicted")
+	}
+	if _, ok := c.get("/c"); !ok {
+		t.Error("want /c cached")
+	}
+
+	c.add("/d", &renderedPage{content: "d"}, &SitemapEntry{Loc: "http://example.com/d"}, -time.Second, 2)
+	if entries := c.sitemapEntries(); len(entries) != 1 || entries[0].Loc != "http://example.com/c" {
+		t.Errorf("want only /c's sitemap entry, got %+v", entries)
+	}
+	if _, ok := c.get("/d"); ok {
+		t.Error("want expired /d not returned")
+	}
+}
+
+func TestRenderCache_sitemapEntries(t *testing.T) {
+	var c renderCache
+	c.add("/b", &renderedPage{}, &SitemapEntry{Loc: "http://example.com/b"}, time.Hour, 10)
+	c.add("/a", &renderedPage{}, &SitemapEntry{Loc: "http://example.com/a", LastMod: "old"}, time.Hour, 10)
+	c.add("/c", &renderedPage{}, &SitemapEntry{Loc: "http://example.com/c"}, time.Hour, 10)
+	c.add("/a?utm=x", &renderedPage{}, &SitemapEntry{Loc: "http://example.com/a", LastMod: "new"}, time.Hour, 10)
+
+	// Using the cache doesn't change the order.
+	c.get("/b")
+
+	entries := c.sitemapEntries()
+	var locs []string
+	for _, e := range entries {
+		locs = append(locs, e.Loc)
+	}
+	if want := []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"}; !reflect.DeepEqual(locs, want) {
+		t.Errorf("want %v, got %v", want, locs)
+	}
+	if entries[0].LastMod != "new" {
+		t.Errorf("want the most recently used entry for a duplicate Loc, got %+v", entries[0])
+	}
+}
@@ -0,0 +1,380 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sourcegraph/webloop"
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the format of the static-reverse-proxy configuration file.
+type Config struct {
+	// Routes determines how requests are handled based on their URL path, for
+	// the single target given by the command-line flags. It is only used if
+	// Hosts is empty.
+	Routes []Route `json:"routes" yaml:"routes" toml:"routes"`
+
+	// Hosts are virtual hosts, each with its own target, selected by the Host
+	// header of incoming requests. If set, the -target, -wait, -unfinished,
+	// -remove-scripts and -escaped-fragment flags are ignored.
+	Hosts []VirtualHost `json:"hosts" yaml:"hosts" toml:"hosts"`
+
+	// Robots determines how rendered pages' robots directives affect the
+	// responses for them, for the single target given by the command-line
+	// flags. If set, the -robots-header, -noindex-status, -no-cache-noindex
+	// and -status-meta flags are ignored. It is only used if Hosts is empty.
+	Robots []RobotsRule `json:"robots" yaml:"robots" toml:"robots"`
+}
+
+// VirtualHost configures how requests for one or more host names are served.
+type VirtualHost struct {
+	// Names are the host names (without ports) that this virtual host serves.
+	// The name "*" matches all hosts that are not matched by another virtual
+	// host.
+	Names []string `json:"names" yaml:"names" toml:"names"`
+
+	// Target is the base URL of the dynamic application to render.
+	Target string `json:"target" yaml:"target" toml:"target"`
+
+	// Wait is the maximum time to wait for a page to become ready.
+	Wait Duration `json:"wait" yaml:"wait" toml:"wait"`
+
+	// Ready is how to tell when a page is ready to be rendered: "load" to
+	// render pages as soon as they load, or a JavaScript expression that
+	// becomes true when the page is ready. If empty,
+	// window.$renderStaticReady is used.
+	Ready string `json:"ready" yaml:"ready" toml:"ready"`
+
+	// Unfinished is whether to return unfinished pages at the wait timeout
+	// instead of an error.
+	Unfinished bool `json:"unfinished" yaml:"unfinished" toml:"unfinished"`
+
+	// Routes determines how requests are handled based on their URL path.
+	Routes []Route `json:"routes" yaml:"routes" toml:"routes"`
+
+	// Transforms are applied to requests and rendered pages. See the
+	// Transform constants for the allowed values.
+	Transforms []Transform `json:"transforms" yaml:"transforms" toml:"transforms"`
+
+	// Cache is the caching policy for rendered pages.
+	Cache CachePolicy `json:"cache" yaml:"cache" toml:"cache"`
+
+	// Sitemap is whether to serve /sitemap.xml listing the cached pages.
+	Sitemap bool `json:"sitemap" yaml:"sitemap" toml:"sitemap"`
+
+	// Metadata is whether to answer requests with an X-Render-Metadata
+	// header with the rendered page's metadata as JSON.
+	Metadata bool `json:"metadata" yaml:"metadata" toml:"metadata"`
+
+	// Formats is whether clients can ask for rendered pages as text,
+	// readable text or Markdown with the _format query parameter or the
+	// Accept header.
+	Formats bool `json:"formats" yaml:"formats" toml:"formats"`
+
+	// Robots determines how rendered pages' robots directives affect the
+	// responses for them, based on their URL path.
+	Robots []RobotsRule `json:"robots" yaml:"robots" toml:"robots"`
+
+	// StatusChecks detect error pages (such as client-side "Not found"
+	// pages) to serve with an HTTP error status instead of 200 OK.
+	StatusChecks []StatusCheck `json:"status_checks" yaml:"status_checks" toml:"status_checks"`
+
+	// StatusVariable is whether pages can set the response's status code
+	// by setting window.$renderStatusCode.
+	StatusVariable bool `json:"status_variable" yaml:"status_variable" toml:"status_variable"`
+
+	// Recycle determines when the view used for rendering is replaced.
+	Recycle RecyclePolicy `json:"recycle" yaml:"recycle" toml:"recycle"`
+}
+
+// RecyclePolicy determines when a virtual host's view (and its WebKit web
+// process) is replaced with a new one. Zero values mean no limit.
+type RecyclePolicy struct {
+	// Loads is the number of pages after which the view is replaced.
+	Loads int `json:"loads" yaml:"loads" toml:"loads"`
+
+	// Age is the duration after which the view is replaced.
+	Age Duration `json:"age" yaml:"age" toml:"age"`
+
+	// MemoryMB is the web process's resident memory, in megabytes, above
+	// which the view is replaced. It can only be set if there is a single
+	// virtual host, because the web process of each host's view can't be
+	// told apart.
+	MemoryMB uint64 `json:"memory_mb" yaml:"memory_mb" toml:"memory_mb"`
+}
+
+// Transform is a named transformation applied when rendering pages.
+type Transform string
+
+const (
+	// RemoveScripts disables <script> tags in rendered pages.
+	RemoveScripts Transform = "remove-scripts"
+
+	// EscapedFragment translates _escaped_fragment_ query parameters into
+	// hashbang URLs.
+	EscapedFragment Transform = "escaped-fragment"
+)
+
+// CachePolicy determines how rendered pages are cached.
+type CachePolicy struct {
+	// TTL is how long rendered pages are cached. If zero, they are not cached.
+	TTL Duration `json:"ttl" yaml:"ttl" toml:"ttl"`
+
+	// Size is the maximum number of cached pages.
+	Size int `json:"size" yaml:"size" toml:"size"`
+}
+
+// RobotsRule determines how the robots directives (from <meta name="robots">
+// and X-Robots-Tag) of rendered pages under a URL path prefix affect the
+// responses for them. See webloop.RobotsRule.
+type RobotsRule struct {
+	Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+
+	// Header is whether to send the directives in an X-Robots-Tag header.
+	Header bool `json:"header" yaml:"header" toml:"header"`
+
+	// NoindexStatus is the HTTP status code to respond with for noindex
+	// pages (0 to respond normally).
+	NoindexStatus int `json:"noindex_status" yaml:"noindex_status" toml:"noindex_status"`
+
+	// NoCacheNoindex is whether noindex pages are not cached.
+	NoCacheNoindex bool `json:"no_cache_noindex" yaml:"no_cache_noindex" toml:"no_cache_noindex"`
+
+	// StatusMeta is whether pages can set the response's status code with
+	// <meta name="prerender-status-code">.
+	StatusMeta bool `json:"status_meta" yaml:"status_meta" toml:"status_meta"`
+}
+
+// robotsRules converts rules to the webloop package's type.
+func robotsRules(rules []RobotsRule) []webloop.RobotsRule {
+	var rs []webloop.RobotsRule
+	for _, r := range rules {
+		rs = append(rs, webloop.RobotsRule{
+			Prefix:         r.Prefix,
+			Header:         r.Header,
+			NoindexStatus:  r.NoindexStatus,
+			NoCacheNoindex: r.NoCacheNoindex,
+			StatusMeta:     r.StatusMeta,
+		})
+	}
+	return rs
+}
+
+// StatusCheck detects error pages. A page matches if all of the conditions
+// that are set match. See webloop.StatusCheck.
+type StatusCheck struct {
+	// Expression is a JavaScript expression that is true on error pages.
+	Expression string `json:"expression" yaml:"expression" toml:"expression"`
+
+	// Selector is a CSS selector that matches an element on error pages.
+	Selector string `json:"selector" yaml:"selector" toml:"selector"`
+
+	// Title is a regular expression that matches the titles of error pages.
+	Title string `json:"title" yaml:"title" toml:"title"`
+
+	// Status is the HTTP status code for error pages (404 if zero).
+	Status int `json:"status" yaml:"status" toml:"status"`
+}
+
+// statusChecks converts checks to the webloop package's type. The checks must
+// have been validated.
+func statusChecks(checks []StatusCheck) []webloop.StatusCheck {
+	var cs []webloop.StatusCheck
+	for _, c := range checks {
+		wc := webloop.StatusCheck{Expression: c.Expression, Selector: c.Selector, Status: c.Status}
+		if c.Title != "" {
+			wc.Title = regexp.MustCompile(c.Title)
+		}
+		cs = append(cs, wc)
+	}
+	return cs
+}
+
+// Duration is a time.Duration that is written as a string such as "3s" in
+// config files.
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Action is how a request is handled.
+type Action string
+
+const (
+	// Render serves a statically rendered HTML snapshot of the target page.
+	Render Action = "render"
+
+	// Proxy forwards the request to the target and streams back its response.
+	Proxy Action = "proxy"
+
+	// Redirect sends an HTTP 302 redirect to the same URL on the target.
+	Redirect Action = "redirect"
+)
+
+// Route associates a URL path prefix with an action.
+type Route struct {
+	Prefix string `json:"prefix" yaml:"prefix" toml:"prefix"`
+	Action Action `json:"action" yaml:"action" toml:"action"`
+}
+
+// readConfig reads and validates the configuration file at path. The file's
+// format (JSON, YAML or TOML) is determined by its extension.
+func readConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var c Config
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		err = dec.Decode(&c)
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, &c)
+	case ".toml":
+		var md toml.MetaData
+		md, err = toml.Decode(string(data), &c)
+		if err == nil && len(md.Undecoded()) > 0 {
+			err = fmt.Errorf("unknown keys %v", md.Undecoded())
+		}
+	default:
+		err = fmt.Errorf("unknown config file format %q (must be .json, .yaml, .yml or .toml)", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if err := c.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return &c, nil
+}
+
+func (c *Config) validate() error {
+	if err := validateRoutes("routes", c.Routes); err != nil {
+		return err
+	}
+	if err := validateRobots("robots", c.Robots); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for i, vh := range c.Hosts {
+		field := fmt.Sprintf("hosts[%d]", i)
+		if len(vh.Names) == 0 {
+			return fmt.Errorf("%s: no names", field)
+		}
+		for _, name := range vh.Names {
+			name = strings.ToLower(name)
+			if seen[name] {
+				return fmt.Errorf("%s: host name %q is used by more than one virtual host", field, name)
+			}
+			seen[name] = true
+		}
+		if u, err := url.Parse(vh.Target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s: target %q must be an absolute http or https URL", field, vh.Target)
+		}
+		if vh.Wait < 0 {
+			return fmt.Errorf("%s: wait must not be negative", field)
+		}
+		if err := validateRoutes(field+".routes", vh.Routes); err != nil {
+			return err
+		}
+		if err := validateRobots(field+".robots", vh.Robots); err != nil {
+			return err
+		}
+		if err := validateStatusChecks(field+".status_checks", vh.StatusChecks); err != nil {
+			return err
+		}
+		for j, t := range vh.Transforms {
+			switch t {
+			case RemoveScripts, EscapedFragment:
+			default:
+				return fmt.Errorf("%s.transforms[%d]: unknown transform %q (must be %q or %q)", field, j, t, RemoveScripts, EscapedFragment)
+			}
+		}
+		if vh.Cache.TTL < 0 || vh.Cache.Size < 0 {
+			return fmt.Errorf("%s.cache: ttl and size must not be negative", field)
+		}
+		if vh.Recycle.Loads < 0 || vh.Recycle.Age < 0 {
+			return fmt.Errorf("%s.recycle: loads and age must not be negative", field)
+		}
+		if vh.Recycle.MemoryMB > 0 && len(c.Hosts) > 1 {
+			return fmt.Errorf("%s.recycle: memory_mb requires a single virtual host", field)
+		}
+		if vh.Sitemap && vh.Cache.TTL == 0 {
+			return fmt.Errorf("%s: sitemap requires cache.ttl to be set", field)
+		}
+	}
+	return nil
+}
+
+func validateRoutes(field string, routes []Route) error {
+	for i, r := range routes {
+		if !strings.HasPrefix(r.Prefix, "/") {
+			return fmt.Errorf("%s[%d]: prefix %q must begin with \"/\"", field, i, r.Prefix)
+		}
+		switch r.Action {
+		case Render, Proxy, Redirect:
+		default:
+			return fmt.Errorf("%s[%d]: unknown action %q (must be %q, %q or %q)", field, i, r.Action, Render, Proxy, Redirect)
+		}
+	}
+	return nil
+}
+
+func validateRobots(field string, rules []RobotsRule) error {
+	for i, r := range rules {
+		if !strings.HasPrefix(r.Prefix, "/") {
+			return fmt.Errorf("%s[%d]: prefix %q must begin with \"/\"", field, i, r.Prefix)
+		}
+		if r.NoindexStatus != 0 && (r.NoindexStatus < 100 || r.NoindexStatus > 599) {
+			return fmt.Errorf("%s[%d]: invalid noindex_status %d", field, i, r.NoindexStatus)
+		}
+	}
+	return nil
+}
+
+func validateStatusChecks(field string, checks []StatusCheck) error {
+	for i, c := range checks {
+		if c.Expression == "" && c.Selector == "" && c.Title == "" {
+			return fmt.Errorf("%s[%d]: one of expression, selector or title must be set", field, i)
+		}
+		if _, err := regexp.Compile(c.Title); err != nil {
+			return fmt.Errorf("%s[%d]: invalid title regexp: %s", field, i, err)
+		}
+		if c.Status != 0 && (c.Status < 100 || c.Status > 599) {
+			return fmt.Errorf("%s[%d]: invalid status %d", field, i, c.Status)
+		}
+	}
+	return nil
+}
+
+// routesFromPrefixes returns a route with the given action for each prefix in
+// the comma-separated list prefixes.
+func routesFromPrefixes(prefixes string, action Action) []Route {
+	if prefixes == "" {
+		return nil
+	}
+	var routes []Route
+	for _, p := range strings.Split(prefixes, ",") {
+		routes = append(routes, Route{Prefix: p, Action: action})
+	}
+	return routes
+}
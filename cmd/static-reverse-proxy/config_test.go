@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static-reverse-proxy-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	want := &Config{Hosts: []VirtualHost{{
+		Names:      []string{"www.example.com", "example.com"},
+		Target:     "http://app.internal:3000",
+		Wait:       Duration(5 * time.Second),
+		Routes:     []Route{{Prefix: "/api", Action: Proxy}},
+		Transforms: []Transform{RemoveScripts},
+		Cache:      CachePolicy{TTL: Duration(10 * time.Minute), Size: 500},
+	}}}
+	files := map[string]string{
+		"config.json": `{"hosts": [{"names": ["www.example.com", "example.com"], "target": "http://app.internal:3000", "wait": "5s",
+  "routes": [{"prefix": "/api", "action": "proxy"}], "transforms": ["remove-scripts"], "cache": {"ttl": "10m", "size": 500}}]}`,
+		"config.yaml": `hosts:
+- names: [www.example.com, example.com]
+  target: http://app.internal:3000
+  wait: 5s
+  routes: [{prefix: /api, action: proxy}]
+  transforms: [remove-scripts]
+  cache: {ttl: 10m, size: 500}
+`,
+		"config.toml": `[[hosts]]
+names = ["www.example.com", "example.com"]
+target = "http://app.internal:3000"
+wait = "5s"
+routes = [{prefix = "/api", action = "proxy"}]
+transforms = ["remove-scripts"]
+cache = {ttl = "10m", size = 500}
+`,
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		c, err := readConfig(path)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(c, want) {
+			t.Errorf("%s: want %+v, got %+v", name, want, c)
+		}
+	}
+
+	invalid := map[string]string{
+		"unknown.json": `{"hosts": [{"names": ["a"], "target": "http://a", "bogus": 1}]}`,
+		"unknown.yaml": "hosts:\n- names: [a]\n  target: http://a\n  bogus: 1\n",
+		"unknown.toml": "[[hosts]]\nnames = [\"a\"]\ntarget = \"http://a\"\nbogus = 1\n",
+		"config.ini":   "",
+		"invalid.yaml": "hosts:\n- names: [a]\n  target: ftp://a\n",
+	}
+	for name, data := range invalid {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := readConfig(path); err == nil || !strings.Contains(err.Error(), path) {
+			t.Errorf("%s: want error mentioning the file, got %v", name, err)
+		}
+	}
+}
+
+func TestConfig_validate(t *testing.T) {
+	host := func(f func(*VirtualHost)) Config {
+		vh := VirtualHost{Names: []string{"example.com"}, Target: "http://app.internal"}
+		f(&vh)
+		return Config{Hosts: []VirtualHost{vh}}
+	}
+	tests := []struct {
+		config  Config
+		wantErr string // substring of the error, or "" for a valid config
+	}{
+		{config: host(func(*VirtualHost) {})},
+		{config: Config{Routes: []Route{{Prefix: "api", Action: Proxy}}}, wantErr: `routes[0]: prefix "api" must begin with "/"`},
+		{config: Config{Routes: []Route{{Prefix: "/api", Action: "forward"}}}, wantErr: `routes[0]: unknown action "forward"`},
+		{config: host(func(vh *VirtualHost) { vh.Names = nil }), wantErr: "hosts[0]: no names"},
+		{
+			config:  Config{Hosts: []VirtualHost{{Names: []string{"a.com"}, Target: "http://a"}, {Names: []string{"A.com"}, Target: "http://b"}}},
+			wantErr: `hosts[1]: host name "a.com" is used by more than one virtual host`,
+		},
+		{config: host(func(vh *VirtualHost) { vh.Target = "app.internal" }), wantErr: "hosts[0]: target"},
+		{config: host(func(vh *VirtualHost) { vh.Wait = -1 }), wantErr: "hosts[0]: wait must not be negative"},
+		{config: host(func(vh *VirtualHost) { vh.Transforms = []Transform{"minify"} }), wantErr: `hosts[0].transforms[0]: unknown transform "minify"`},
+		{config: host(func(vh *VirtualHost) { vh.Cache.Size = -1 }), wantErr: "hosts[0].cache"},
+		{config: host(func(vh *VirtualHost) { vh.Recycle.Loads = -1 }), wantErr: "hosts[0].recycle"},
+		{config: host(func(vh *VirtualHost) { vh.Sitemap = true }), wantErr: "hosts[0]: sitemap requires cache.ttl"},
+		{config: host(func(vh *VirtualHost) { vh.Recycle.MemoryMB = 1024 })},
+		{
+			config: Config{Hosts: []VirtualHost{
+				{Names: []string{"a.com"}, Target: "http://a", Recycle: RecyclePolicy{MemoryMB: 1024}},
+				{Names: []string{"b.com"}, Target: "http://b"},
+			}},
+			wantErr: "hosts[0].recycle: memory_mb requires a single virtual host",
+		},
+		{config: host(func(vh *VirtualHost) { vh.Robots = []RobotsRule{{Prefix: "/", NoindexStatus: 1000}} }), wantErr: "hosts[0].robots[0]: invalid noindex_status"},
+		{config: host(func(vh *VirtualHost) { vh.StatusChecks = []StatusCheck{{Status: 404}} }), wantErr: "hosts[0].status_checks[0]: one of"},
+		{config: host(func(vh *VirtualHost) { vh.StatusChecks = []StatusCheck{{Title: "("}} }), wantErr: "hosts[0].status_checks[0]: invalid title regexp"},
+	}
+	for i, test := range tests {
+		err := test.config.validate()
+		switch {
+		case test.wantErr == "" && err != nil:
+			t.Errorf("%d: want valid, got %s", i, err)
+		case test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)):
+			t.Errorf("%d: want error containing %q, got %v", i, test.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/webloop"
+)
+
+// hostRouter dispatches requests to the handler for the virtual host named by
+// their Host header.
+type hostRouter struct {
+	hosts    map[string]*handler
+	fallback *handler // for the "*" virtual host, or nil
+
+	handlers []*handler // one per virtual host
+}
+
+// newHostRouter creates a router for the virtual hosts, which must already have
+// been validated.
+func newHostRouter(vhosts []VirtualHost, log *log.Logger, metrics webloop.Metrics) *hostRouter {
+	hr := &hostRouter{hosts: map[string]*handler{}}
+	for _, vh := range vhosts {
+		h := newVirtualHostHandler(vh, log, metrics)
+		hr.handlers = append(hr.handlers, h)
+		for _, name := range vh.Names {
+			if name == "*" {
+				hr.fallback = h
+			} else {
+				hr.hosts[strings.ToLower(name)] = h
+			}
+		}
+	}
+	return hr
+}
+
+// ServeHTTP implements net/http.Handler.
+func (hr *hostRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	h, ok := hr.hosts[strings.ToLower(host)]
+	if !ok {
+		h = hr.fallback
+	}
+	if h == nil {
+		http.Error(w, "unknown host "+host, http.StatusNotFound)
+		return
+	}
+	h.ServeHTTP(w, r)
+}
+
+// defaultWait is the wait timeout for virtual hosts that don't specify one.
+const defaultWait = 3 * time.Second
+
+// newVirtualHostHandler creates a handler that serves the virtual host vh.
+func newVirtualHostHandler(vh VirtualHost, log *log.Logger, metrics webloop.Metrics) *handler {
+	target, _ := url.Parse(vh.Target)
+	r := &webloop.StaticRenderer{
+		TargetBaseURL:         strings.TrimSuffix(vh.Target, "/"),
+		Context:               newContext(metrics),
+		WaitTimeout:           time.Duration(vh.Wait),
+		ReadyExpression:       vh.Ready,
+		ReturnUnfinishedPages: vh.Unfinished,
+		CacheTTL:              time.Duration(vh.Cache.TTL),
+		CacheSize:             vh.Cache.Size,
+		CollectSitemap:        vh.Sitemap,
+		ServeMetadata:         vh.Metadata,
+		ServeFormats:          vh.Formats,
+		Robots:                robotsRules(vh.Robots),
+		StatusChecks:          statusChecks(vh.StatusChecks),
+		UseStatusCodeVariable: vh.StatusVariable,
+		RecycleAfterLoads:     vh.Recycle.Loads,
+		RecycleAfterAge:       time.Duration(vh.Recycle.Age),
+		RecycleAboveMemory:    vh.Recycle.MemoryMB << 20,
+		Log:                   log,
+	}
+	if r.WaitTimeout == 0 {
+		r.WaitTimeout = defaultWait
+	}
+	if r.ReadyExpression == "load" {
+		r.ReadyExpression = "true"
+	}
+	for _, t := range vh.Transforms {
+		switch t {
+		case RemoveScripts:
+			r.RemoveScripts = true
+		case EscapedFragment:
+			r.TranslateEscapedFragment = true
+		}
+	}
+	return newHandler(target, vh.Routes, r)
+}
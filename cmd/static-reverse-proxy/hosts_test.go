@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHostRouter(t *testing.T) {
+	target := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte(name))
+		}))
+	}
+	a, other := target("a"), target("other")
+	defer a.Close()
+	defer other.Close()
+
+	// All paths are proxied, so that no pages are rendered.
+	routes := []Route{{Prefix: "/", Action: Proxy}}
+	hr := newHostRouter([]VirtualHost{
+		{Names: []string{"a.example.com", "A2.example.com"}, Target: a.URL, Routes: routes},
+		{Names: []string{"*"}, Target: other.URL, Routes: routes},
+	}, log.New(ioutil.Discard, "", 0), nil)
+
+	tests := map[string]string{
+		"a.example.com":      "a",
+		"a.example.com:8080": "a",
+		"a2.example.com":     "a",
+		"A.EXAMPLE.COM":      "a",
+		"b.example.com":      "other",
+		"example.com":        "other",
+	}
+	for host, want := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		hr.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != want {
+			t.Errorf("%s: want %q, got %q", host, want, got)
+		}
+	}
+
+	// Without a "*" virtual host, unknown hosts are not found.
+	hr = newHostRouter([]VirtualHost{{Names: []string{"a.example.com"}, Target: a.URL, Routes: routes}}, log.New(ioutil.Discard, "", 0), nil)
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Host = "b.example.com"
+	rec := httptest.NewRecorder()
+	hr.ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown host: want status %d, got %d", http.StatusNotFound, rec.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/webloop"
+)
+
+// handler dispatches requests to the renderer, to a reverse proxy for the
+// target, or to a redirect, according to its routes.
+type handler struct {
+	routes   []Route
+	target   *url.URL
+	proxy    http.Handler
+	renderer *webloop.StaticRenderer
+}
+
+func newHandler(target *url.URL, routes []Route, renderer *webloop.StaticRenderer) *handler {
+	return &handler{
+		routes:   routes,
+		target:   target,
+		proxy:    newReverseProxy(target),
+		renderer: renderer,
+	}
+}
+
+// ServeHTTP implements net/http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.renderer.CollectSitemap && r.URL.Path == "/sitemap.xml" {
+		h.renderer.ServeSitemap(w, r)
+		return
+	}
+	switch h.action(r.URL.Path) {
+	case Proxy:
+		h.proxy.ServeHTTP(w, r)
+	case Redirect:
+		http.Redirect(w, r, strings.TrimSuffix(h.target.String(), "/")+r.URL.String(), http.StatusFound)
+	default:
+		h.renderer.ServeHTTP(w, r)
+	}
+}
+
+// action returns the action of the first route whose prefix matches path.
+func (h *handler) action(path string) Action {
+	for _, rt := range h.routes {
+		if strings.HasPrefix(path, rt.Prefix) {
+			return rt.Action
+		}
+	}
+	return Render
+}
+
+// newReverseProxy returns a reverse proxy that forwards requests to target
+// without exposing the target's host to clients. Responses are streamed, and
+// the X-Forwarded-For, X-Forwarded-Host and X-Forwarded-Proto headers are set
+// on forwarded requests.
+func newReverseProxy(target *url.URL) *httputil.ReverseProxy {
+	p := httputil.NewSingleHostReverseProxy(target)
+	director := p.Director
+	p.Director = func(r *http.Request) {
+		// ReverseProxy sets X-Forwarded-For itself after the director runs.
+		r.Header.Set("X-Forwarded-Host", r.Host)
+		if r.TLS != nil {
+			r.Header.Set("X-Forwarded-Proto", "https")
+		} else {
+			r.Header.Set("X-Forwarded-Proto", "http")
+		}
+		director(r)
+		r.Host = target.Host
+	}
+	p.FlushInterval = 100 * time.Millisecond
+	p.ModifyResponse = func(resp *http.Response) error {
+		rewriteLocation(resp, target)
+		return nil
+	}
+	return p
+}
+
+// rewriteLocation rewrites absolute redirects to the target in resp's Location
+// header so that they point to the proxy instead.
+func rewriteLocation(resp *http.Response, target *url.URL) {
+	loc, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || loc.Host == "" {
+		return
+	}
+	if !strings.EqualFold(loc.Host, target.Host) {
+		return
+	}
+	loc.Scheme, loc.Host = "", ""
+	resp.Header.Set("Location", loc.String())
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func TestRewriteLocation(t *testing.T) {
+	target, err := url.Parse("http://app.internal:3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		location string
+		want     string
+	}{
+		{"", ""},
+		{"/login", "/login"},
+		{"http://app.internal:3000/login?next=%2Fa#top", "/login?next=%2Fa#top"},
+		{"https://APP.internal:3000/login", "/login"},
+		{"http://app.internal/login", "http://app.internal/login"},
+		{"http://example.com/login", "http://example.com/login"},
+		{"//app.internal:3000/login", "/login"},
+	}
+	for _, test := range tests {
+		resp := &http.Response{Header: http.Header{}}
+		if test.location != "" {
+			resp.Header.Set("Location", test.location)
+		}
+		rewriteLocation(resp, target)
+		if got := resp.Header.Get("Location"); got != test.want {
+			t.Errorf("%q: want %q, got %q", test.location, test.want, got)
+		}
+	}
+}
+
+func TestHandler_action(t *testing.T) {
+	h := &handler{routes: []Route{
+		{Prefix: "/api/render", Action: Render},
+		{Prefix: "/api", Action: Proxy},
+		{Prefix: "/static", Action: Redirect},
+	}}
+	tests := map[string]Action{
+		"/":              Render,
+		"/api/users":     Proxy,
+		"/api/render/x":  Render,
+		"/static/app.js": Redirect,
+		"/apis":          Proxy,
+	}
+	for path, want := range tests {
+		if got := h.action(path); got != want {
+			t.Errorf("%s: want %q, got %q", path, want, got)
+		}
+	}
+}
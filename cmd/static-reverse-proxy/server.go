@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/sourcegraph/webloop"
+)
+
+// site is the handler for a loaded configuration, along with the renderers it
+// uses, so that they can be released when the site is reloaded or shut down.
+type site struct {
+	http.Handler
+	renderers []releaser
+	desc      string
+
+	inflight sync.WaitGroup
+}
+
+// releaser releases the views that it renders pages in. It is implemented by
+// *webloop.StaticRenderer.
+type releaser interface {
+	Release()
+}
+
+// release waits for the site's in-flight requests to finish and then releases
+// its renderers' views. If ctx is done first, it returns ctx.Err() without
+// releasing them, because closing a view during a render could crash WebKit.
+func (s *site) release(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		for _, r := range s.renderers {
+			r.Release()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// swapHandler serves requests using its current site, which can be replaced
+// without interrupting the listener or the requests that the old site is
+// serving.
+type swapHandler struct {
+	mu   sync.RWMutex
+	site *site
+
+	metrics webloop.Metrics // passed to reloaded sites
+}
+
+// ServeHTTP implements net/http.Handler.
+func (sh *swapHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	sh.mu.RLock()
+	s := sh.site
+	s.inflight.Add(1)
+	sh.mu.RUnlock()
+	defer s.inflight.Done()
+	s.ServeHTTP(w, r)
+}
+
+// swap makes s the current site and returns the previous one.
+func (sh *swapHandler) swap(s *site) (old *site) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	old, sh.site = sh.site, s
+	return old
+}
+
+// serveUntilSignaled reloads the site on SIGHUP and gracefully shuts down srv
+// on SIGTERM or SIGINT.
+func serveUntilSignaled(srv *http.Server, sh *swapHandler, log *log.Logger) {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+	for sig := range sigc {
+		if sig == syscall.SIGHUP {
+			reload(sh, log)
+			continue
+		}
+
+		log.Printf("Received %s; shutting down (waiting up to %s for in-flight requests)", sig, *shutdownTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown: %s", err)
+		}
+		sh.mu.RLock()
+		s := sh.site
+		sh.mu.RUnlock()
+		if err := s.release(ctx); err != nil {
+			log.Printf("Not releasing views with renders still in flight: %s", err)
+		}
+		cancel()
+		return
+	}
+}
+
+// reload loads the site again (rereading the config file) and swaps it in. The
+// old site's views are released after its in-flight requests finish.
+func reload(sh *swapHandler, log *log.Logger) {
+	s, err := loadSite(log, sh.metrics)
+	if err != nil {
+		log.Printf("Reload failed; continuing with old config: %s", err)
+		return
+	}
+	old := sh.swap(s)
+	log.Printf("Reloaded; now proxying against %s", s.desc)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		if err := old.release(ctx); err != nil {
+			log.Printf("Not releasing old views with renders still in flight: %s", err)
+		}
+	}()
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// stubRenderer records when it is released. Like a StaticRenderer, it may be
+// released more than once.
+type stubRenderer struct {
+	once     sync.Once
+	released chan struct{}
+}
+
+func newStubRenderer() *stubRenderer { return &stubRenderer{released: make(chan struct{})} }
+
+func (r *stubRenderer) Release() { r.once.Do(func() { close(r.released) }) }
+
+func (r *stubRenderer) isReleased() bool {
+	select {
+	case <-r.released:
+		return true
+	default:
+		return false
+	}
+}
+
+// stubSite returns a site that responds with name and releases r.
+func stubSite(name string, r *stubRenderer) *site {
+	return &site{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.Write([]byte(name))
+		}),
+		renderers: []releaser{r},
+		desc:      name,
+	}
+}
+
+func TestSwapHandler_swap(t *testing.T) {
+	// The old site's request blocks until unblock is closed.
+	entered, unblock := make(chan struct{}), make(chan struct{})
+	oldRenderer := newStubRenderer()
+	old := &site{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			close(entered)
+			<-unblock
+			w.Write([]byte("old"))
+		}),
+		renderers: []releaser{oldRenderer},
+	}
+	sh := &swapHandler{site: old}
+
+	oldDone := make(chan string)
+	go func() {
+		rw := httptest.NewRecorder()
+		sh.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+		oldDone <- rw.Body.String()
+	}()
+	<-entered
+
+	if got := sh.swap(stubSite("new", newStubRenderer())); got != old {
+		t.Fatalf("want swap to return the old site, got %v", got)
+	}
+	rw := httptest.NewRecorder()
+	sh.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	if got := rw.Body.String(); got != "new" {
+		t.Errorf("after swap: want response %q, got %q", "new", got)
+	}
+
+	// The old site isn't released while its request is in flight...
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := old.release(ctx); err != context.DeadlineExceeded {
+		t.Errorf("with request in flight: want context.DeadlineExceeded, got %v", err)
+	}
+	if oldRenderer.isReleased() {
+		t.Error("with request in flight: old renderer released")
+	}
+
+	// ...which finishes with the old site.
+	close(unblock)
+	if got := <-oldDone; got != "old" {
+		t.Errorf("in-flight request: want response %q, got %q", "old", got)
+	}
+	if err := old.release(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if !oldRenderer.isReleased() {
+		t.Error("old renderer not released")
+	}
+}
+
+func TestReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "static-reverse-proxy-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	defer func(orig string) { *configFile = orig }(*configFile)
+	*configFile = path
+
+	oldRenderer := newStubRenderer()
+	old := stubSite("old", oldRenderer)
+	sh := &swapHandler{site: old}
+	discard := log.New(ioutil.Discard, "", 0)
+
+	// An invalid config keeps the old site.
+	if err := ioutil.WriteFile(path, []byte("hosts:\n- names: [a]\n  target: ftp://a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reload(sh, discard)
+	if sh.site != old {
+		t.Error("invalid config: want old site kept")
+	}
+	if oldRenderer.isReleased() {
+		t.Error("invalid config: old renderer released")
+	}
+
+	// A valid config replaces it, and the old site is released.
+	if err := ioutil.WriteFile(path, []byte("hosts:\n- names: [a.example.com]\n  target: http://app.internal\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	reload(sh, discard)
+	if sh.site == old {
+		t.Fatal("valid config: want new site")
+	}
+	defer sh.site.release(context.Background())
+	select {
+	case <-oldRenderer.released:
+	case <-time.After(5 * time.Second):
+		t.Error("valid config: old renderer not released")
+	}
+}
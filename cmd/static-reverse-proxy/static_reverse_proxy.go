@@ -3,20 +3,53 @@ package main
 import (
 	"flag"
 	"fmt"
-	"github.com/sourcegraph/webloop"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sourcegraph/webloop"
+	"github.com/sourcegraph/webloop/prometheus"
 )
 
 var bind = flag.String("http", ":13000", "HTTP bind address")
 var targetURL = flag.String("target", "http://localhost:3000", "base URL of target")
-var waitTimeout = flag.Duration("wait", time.Second*3, "timeout for pages to set window.$renderStaticReady")
+var waitTimeout = flag.Duration("wait", defaultWait, "timeout for pages to set window.$renderStaticReady")
 var returnUnfinishedPages = flag.Bool("unfinished", false, "return unfinished pages at wait timeout (instead of erroring)")
 var removeScripts = flag.Bool("remove-scripts", false, "remove <script> tags")
-var redirectPrefixesStr = flag.String("redirect-prefixes", "/static,/api,/favicon.ico", "comma-separated list of path prefixes to redirect to the target (not proxy and render)")
+var escapedFragment = flag.Bool("escaped-fragment", false, "translate _escaped_fragment_ query parameters (Google's AJAX crawling scheme) into #! URLs")
+var cacheTTL = flag.Duration("cache-ttl", 0, "how long to cache rendered pages (0 to disable caching)")
+var sitemap = flag.Bool("sitemap", false, "serve /sitemap.xml listing the cached pages (requires -cache-ttl)")
+var metadata = flag.Bool("metadata", false, "respond to requests with an X-Render-Metadata header with the rendered page's metadata (as JSON) instead of its HTML")
+var formats = flag.Bool("formats", false, "let clients ask for rendered pages as text, readable text or Markdown with the _format query parameter (text, readable or markdown) or the Accept header (text/plain, text/plain; profile=readable or text/markdown)")
+var robotsHeader = flag.Bool("robots-header", false, "send rendered pages' robots directives (from <meta name=\"robots\"> and X-Robots-Tag) in an X-Robots-Tag header")
+var noindexStatus = flag.Int("noindex-status", 0, "HTTP status code (such as 404) to respond with for rendered pages with a noindex robots directive (0 to respond normally)")
+var noCacheNoindex = flag.Bool("no-cache-noindex", false, "don't cache rendered pages with a noindex robots directive")
+var statusMeta = flag.Bool("status-meta", false, "let pages set the response's status code with <meta name=\"prerender-status-code\" content=\"404\">")
+var notFoundSelector = flag.String("not-found-selector", "", "CSS selector of an element that marks rendered pages as not found (served with HTTP 404)")
+var notFoundTitle = flag.String("not-found-title", "", "regular expression matching the titles of rendered pages that are not found (served with HTTP 404)")
+var notFoundExpression = flag.String("not-found-expression", "", "JavaScript expression that is true on rendered pages that are not found (served with HTTP 404)")
+var statusVariable = flag.Bool("status-variable", false, "let pages set the response's status code by setting window.$renderStatusCode")
+var recycleLoads = flag.Int("recycle-loads", 0, "replace the WebKit view after it loads this many pages (0 for no limit)")
+var recycleAge = flag.Duration("recycle-age", 0, "replace the WebKit view after this long (0 for no limit)")
+var recycleMemory = flag.Uint64("recycle-memory", 0, "replace the WebKit view when its web process uses more than this many MB of memory (0 for no limit)")
+var redirectPrefixesStr = flag.String("redirect-prefixes", "/static,/api,/favicon.ico", "comma-separated list of path prefixes to redirect to the target (not proxy and render); can't be used with -config")
+var proxyPrefixesStr = flag.String("proxy-prefixes", "", "comma-separated list of path prefixes to reverse proxy to the target without rendering; can't be used with -config")
+var shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "maximum time to wait for in-flight renders to finish when shutting down or reloading")
+var metricsPath = flag.String("metrics", "/metrics", "path at which to serve Prometheus metrics (empty to disable)")
+var credentialsFile = flag.String("credentials-file", "", "file of credentials for HTTP authentication with targets, one host=username:password per line (host may include a port)")
+var clientCert = flag.String("client-cert", "", "PEM file of the TLS client certificate to present to targets that request one")
+var clientKey = flag.String("client-key", "", "PEM file of the private key for -client-cert (if not in -client-cert)")
+var caFile = flag.String("ca-file", "", "PEM file of additional certificate authorities to trust for targets")
+var insecureHosts = flag.String("insecure-hosts", "", "comma-separated list of hosts whose TLS certificate errors are ignored")
+var proxy = flag.String("proxy", "", "URL of the proxy (http://, https:// or socks5://) to load targets through, \"none\" to connect directly, or empty to use $HTTP_PROXY, $HTTPS_PROXY and $ALL_PROXY")
+var noProxy = flag.String("no-proxy", "", "comma-separated list of hosts to connect to directly, bypassing the proxy (default $NO_PROXY)")
+var display = flag.String("display", "auto", "display for WebKit: \"existing\" ($DISPLAY), \"xvfb\" (start an Xvfb) or \"auto\" (existing if set, otherwise xvfb)")
+var configFile = flag.String("config", "", "JSON, YAML or TOML config file with per-prefix routes and virtual hosts (see below)")
 
 func main() {
 	flag.Usage = func() {
@@ -34,9 +67,43 @@ func main() {
 		fmt.Fprintf(os.Stderr, "\tTo proxy a dynamic application at http://example.com and serve an equivalent\n")
 		fmt.Fprintf(os.Stderr, "\tstatically rendered HTML website on http://localhost:13000\n")
 		fmt.Fprintf(os.Stderr, "\t    $ static-reverse-proxy -target=http://example.com -bind=:13000\n\n")
+		fmt.Fprintf(os.Stderr, "\tTo choose per path prefix whether to render, proxy or redirect requests,\n")
+		fmt.Fprintf(os.Stderr, "\tuse a config file:\n")
+		fmt.Fprintf(os.Stderr, "\t    {\"routes\": [{\"prefix\": \"/api\", \"action\": \"proxy\"},\n")
+		fmt.Fprintf(os.Stderr, "\t                {\"prefix\": \"/static\", \"action\": \"redirect\"}]}\n")
+		fmt.Fprintf(os.Stderr, "\t    $ static-reverse-proxy -target=http://example.com -config=routes.json\n\n")
+		fmt.Fprintf(os.Stderr, "\tTo serve multiple virtual hosts, each with its own target, list them\n")
+		fmt.Fprintf(os.Stderr, "\tunder \"hosts\" in the config file (here in YAML):\n")
+		fmt.Fprintf(os.Stderr, "\t    hosts:\n")
+		fmt.Fprintf(os.Stderr, "\t    - names: [www.example.com, example.com]\n")
+		fmt.Fprintf(os.Stderr, "\t      target: http://app.internal:3000\n")
+		fmt.Fprintf(os.Stderr, "\t      wait: 5s\n")
+		fmt.Fprintf(os.Stderr, "\t      ready: window.appReady  # or \"load\"\n")
+		fmt.Fprintf(os.Stderr, "\t      routes: [{prefix: /api, action: proxy}]\n")
+		fmt.Fprintf(os.Stderr, "\t      transforms: [remove-scripts, escaped-fragment]\n")
+		fmt.Fprintf(os.Stderr, "\t      cache: {ttl: 10m, size: 500}\n")
+		fmt.Fprintf(os.Stderr, "\t      sitemap: true  # serve /sitemap.xml from the cache\n")
+		fmt.Fprintf(os.Stderr, "\t      metadata: true  # serve page metadata to X-Render-Metadata requests\n")
+		fmt.Fprintf(os.Stderr, "\t      formats: true  # serve ?_format=text, readable or markdown\n")
+		fmt.Fprintf(os.Stderr, "\t      robots: [{prefix: /, header: true, noindex_status: 404, no_cache_noindex: true}]\n")
+		fmt.Fprintf(os.Stderr, "\t      status_checks: [{selector: .not-found}, {title: \"^Gone\", status: 410}]\n")
+		fmt.Fprintf(os.Stderr, "\t      recycle: {loads: 1000, age: 1h, memory_mb: 1024}\n")
+		fmt.Fprintf(os.Stderr, "\t    - names: [\"*\"]\n")
+		fmt.Fprintf(os.Stderr, "\t      target: http://other.internal:3000\n\n")
+		fmt.Fprintf(os.Stderr, "\tTo render a target behind HTTP authentication with a self-signed certificate:\n")
+		fmt.Fprintf(os.Stderr, "\t    $ static-reverse-proxy -target=https://staging.internal \\\n")
+		fmt.Fprintf(os.Stderr, "\t        -credentials-file=credentials.txt -insecure-hosts=staging.internal\n")
+		fmt.Fprintf(os.Stderr, "\twhere credentials.txt contains:\n")
+		fmt.Fprintf(os.Stderr, "\t    staging.internal=user:secret\n\n")
+		fmt.Fprintf(os.Stderr, "Signals:\n\n")
+		fmt.Fprintf(os.Stderr, "\tOn SIGTERM or SIGINT, static-reverse-proxy stops accepting connections,\n")
+		fmt.Fprintf(os.Stderr, "\twaits up to -shutdown-timeout for in-flight requests, releases its WebKit\n")
+		fmt.Fprintf(os.Stderr, "\tviews and exits. On SIGHUP, it reloads its config file without closing\n")
+		fmt.Fprintf(os.Stderr, "\tits listener; if the new config is invalid, the old one remains in use.\n\n")
 		fmt.Fprintf(os.Stderr, "Notes:\n\n")
-		fmt.Fprintf(os.Stderr, "\tBecause a headless WebKit instance is used, your $DISPLAY must be set. Use\n")
-		fmt.Fprintf(os.Stderr, "\tXvfb if you are running on a machine without an existing X server. See\n")
+		fmt.Fprintf(os.Stderr, "\tWebKit needs a display, even though it runs headless. By default, the\n")
+		fmt.Fprintf(os.Stderr, "\texisting $DISPLAY is used if set; otherwise, an Xvfb (which must be\n")
+		fmt.Fprintf(os.Stderr, "\tinstalled) is started and stopped with static-reverse-proxy. See\n")
 		fmt.Fprintf(os.Stderr, "\thttps://sourcegraph.com/github.com/sourcegraph/webloop/readme for more info.\n")
 		fmt.Fprintln(os.Stderr)
 		os.Exit(1)
@@ -45,32 +112,257 @@ func main() {
 
 	log := log.New(os.Stderr, "", 0)
 
-	var redirectPrefixes []string
-	if *redirectPrefixesStr != "" {
-		redirectPrefixes = strings.Split(*redirectPrefixesStr, ",")
+	if err := checkRouteFlags(flag.CommandLine); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		flag.Usage()
 	}
 
-	staticRenderer := &webloop.StaticRenderer{
-		TargetBaseURL:         *targetURL,
-		WaitTimeout:           *waitTimeout,
-		ReturnUnfinishedPages: *returnUnfinishedPages,
-		RemoveScripts:         *removeScripts,
-		Log:                   log,
-	}
-	h := func(w http.ResponseWriter, r *http.Request) {
-		for _, rp := range redirectPrefixes {
-			if strings.HasPrefix(r.URL.Path, rp) {
-				http.Redirect(w, r, *targetURL+r.URL.String(), http.StatusFound)
-				return
+	var err error
+	baseContext, err = contextFromFlags()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	xvfb, err := webloop.SetupDisplay(webloop.DisplayMode(*display))
+	if err != nil {
+		log.Fatalf("Setting up display: %s", err)
+	}
+	stopping := make(chan struct{})
+	if xvfb != nil {
+		log.Printf("Started Xvfb on display %s", xvfb.Display)
+		go func() {
+			select {
+			case <-xvfb.Done():
+				log.Fatalf("Xvfb exited unexpectedly: %v", xvfb.Err())
+			case <-stopping:
 			}
+		}()
+	}
+
+	var metrics webloop.Metrics
+	mux := http.NewServeMux()
+	if *metricsPath != "" {
+		metrics = prometheus.New(nil)
+		mux.Handle(*metricsPath, promhttp.Handler())
+	}
+
+	s, err := loadSite(log, metrics)
+	if err != nil {
+		log.Fatal(err)
+	}
+	sh := &swapHandler{site: s, metrics: metrics}
+	mux.Handle("/", sh)
+	srv := &http.Server{Addr: *bind, Handler: mux}
+	go func() {
+		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("ListenAndServe: %s", err)
 		}
-		staticRenderer.ServeHTTP(w, r)
+	}()
+	log.Printf("Listening on %s and proxying against %s", *bind, s.desc)
+
+	serveUntilSignaled(srv, sh, log)
+	close(stopping)
+	if xvfb != nil {
+		xvfb.Close()
 	}
+}
 
-	http.HandleFunc("/", h)
-	log.Printf("Listening on %s and proxying against %s", *bind, *targetURL)
-	err := http.ListenAndServe(*bind, nil)
+// loadSite creates the site described by the command-line flags and config
+// file. Its renderers report to metrics, if non-nil.
+func loadSite(log *log.Logger, metrics webloop.Metrics) (*site, error) {
+	var config Config
+	if *configFile != "" {
+		c, err := readConfig(*configFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading config: %s", err)
+		}
+		config = *c
+	}
+
+	if len(config.Hosts) > 0 {
+		hr := newHostRouter(config.Hosts, log, metrics)
+		s := &site{Handler: hr, desc: fmt.Sprintf("%d virtual hosts", len(config.Hosts))}
+		for _, h := range hr.handlers {
+			s.renderers = append(s.renderers, h.renderer)
+		}
+		return s, nil
+	}
+
+	target, err := url.Parse(*targetURL)
 	if err != nil {
-		log.Fatalf("ListenAndServe: %s", err)
+		return nil, fmt.Errorf("invalid target URL: %s", err)
+	}
+
+	if *sitemap && *cacheTTL == 0 {
+		return nil, fmt.Errorf("-sitemap requires -cache-ttl to be set")
+	}
+
+	routes := config.Routes
+	if *configFile == "" {
+		routes = append(routesFromPrefixes(*proxyPrefixesStr, Proxy), routesFromPrefixes(*redirectPrefixesStr, Redirect)...)
 	}
+
+	robots := config.Robots
+	if robots == nil && (*robotsHeader || *noindexStatus != 0 || *noCacheNoindex || *statusMeta) {
+		robots = []RobotsRule{{Prefix: "/", Header: *robotsHeader, NoindexStatus: *noindexStatus, NoCacheNoindex: *noCacheNoindex, StatusMeta: *statusMeta}}
+		if err := validateRobots("robots", robots); err != nil {
+			return nil, fmt.Errorf("invalid -noindex-status: %s", err)
+		}
+	}
+
+	var checks []StatusCheck
+	for _, c := range []StatusCheck{{Selector: *notFoundSelector}, {Title: *notFoundTitle}, {Expression: *notFoundExpression}} {
+		if c != (StatusCheck{}) {
+			checks = append(checks, c)
+		}
+	}
+	if err := validateStatusChecks("status_checks", checks); err != nil {
+		return nil, fmt.Errorf("invalid -not-found-title: %s", err)
+	}
+
+	staticRenderer := &webloop.StaticRenderer{
+		TargetBaseURL:            *targetURL,
+		Context:                  newContext(metrics),
+		WaitTimeout:              *waitTimeout,
+		ReturnUnfinishedPages:    *returnUnfinishedPages,
+		RemoveScripts:            *removeScripts,
+		TranslateEscapedFragment: *escapedFragment,
+		CacheTTL:                 *cacheTTL,
+		CollectSitemap:           *sitemap,
+		ServeMetadata:            *metadata,
+		ServeFormats:             *formats,
+		Robots:                   robotsRules(robots),
+		StatusChecks:             statusChecks(checks),
+		UseStatusCodeVariable:    *statusVariable,
+		RecycleAfterLoads:        *recycleLoads,
+		RecycleAfterAge:          *recycleAge,
+		RecycleAboveMemory:       *recycleMemory << 20,
+		Log:                      log,
+	}
+	return &site{
+		Handler:   newHandler(target, routes, staticRenderer),
+		renderers: []releaser{staticRenderer},
+		desc:      *targetURL,
+	}, nil
+}
+
+// baseContext has the WebKit options from the command-line flags. Each
+// renderer gets a copy of it.
+var baseContext webloop.Context
+
+// routeFlags are the flags whose routes a config file's routes replace.
+var routeFlags = []string{"redirect-prefixes", "proxy-prefixes"}
+
+// checkRouteFlags returns an error if fs, which has been parsed, has both
+// -config and any of routeFlags set.
+func checkRouteFlags(fs *flag.FlagSet) error {
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if !set["config"] {
+		return nil
+	}
+	for _, name := range routeFlags {
+		if set[name] {
+			return fmt.Errorf("-%s can't be used with -config; set routes in the config file instead", name)
+		}
+	}
+	return nil
+}
+
+// contextFromFlags returns a Context with the WebKit options from the
+// command-line flags.
+func contextFromFlags() (webloop.Context, error) {
+	c := webloop.Context{
+		ClientCertFile: *clientCert,
+		ClientKeyFile:  *clientKey,
+		CAFile:         *caFile,
+	}
+	if *insecureHosts != "" {
+		c.InsecureHosts = strings.Split(*insecureHosts, ",")
+	}
+	var err error
+	if *credentialsFile != "" {
+		if c.Credentials, err = readCredentials(*credentialsFile); err != nil {
+			return c, err
+		}
+	}
+	if c.Proxy, err = proxyFromFlags(*proxy, *noProxy, webloop.ProxyFromEnvironment()); err != nil {
+		return c, err
+	}
+	if *clientKey != "" && *clientCert == "" {
+		return c, fmt.Errorf("-client-key requires -client-cert")
+	}
+	return c, nil
+}
+
+// proxyFromFlags returns the proxy settings for the -proxy and -no-proxy flags,
+// given the proxy settings from the environment (or nil if there are none).
+func proxyFromFlags(proxy, noProxy string, env *webloop.ProxySettings) (*webloop.ProxySettings, error) {
+	var p *webloop.ProxySettings
+	switch proxy {
+	case "":
+		p = env
+	case "none":
+		p = &webloop.ProxySettings{}
+	default:
+		u, err := url.Parse(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -proxy: %s", err)
+		}
+		// WebKitGTK+'s proxy API has no way to use a PAC file.
+		if strings.HasSuffix(u.Path, ".pac") || strings.HasSuffix(u.Path, ".dat") {
+			return nil, fmt.Errorf("invalid -proxy %q: proxy auto-config (PAC) files aren't supported (configure them in the system proxy settings instead)", proxy)
+		}
+		switch u.Scheme {
+		case "http", "https":
+			p = &webloop.ProxySettings{HTTP: proxy, HTTPS: proxy}
+		case "socks", "socks4", "socks4a", "socks5":
+			p = &webloop.ProxySettings{SOCKS: proxy}
+		default:
+			return nil, fmt.Errorf("invalid -proxy %q: want an http://, https:// or socks5:// URL", proxy)
+		}
+	}
+	if noProxy != "" {
+		if p == nil {
+			return nil, fmt.Errorf("-no-proxy requires -proxy (or $HTTP_PROXY, $HTTPS_PROXY or $ALL_PROXY)")
+		}
+		p.Bypass = strings.Split(noProxy, ",")
+	}
+	return p, nil
+}
+
+// readCredentials reads a credentials file, which has a host=username:password
+// line for each host (with blank lines and lines starting with "#" ignored).
+// Passwords are read from a file so that they aren't visible in the process's
+// command line.
+func readCredentials(path string) (map[string]webloop.Credentials, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading credentials: %s", err)
+	}
+	creds := map[string]webloop.Credentials{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// Hosts can't contain "=" and usernames can't contain ":", so
+		// the password is everything after them.
+		hostUser := strings.SplitN(line, "=", 2)
+		if len(hostUser) != 2 || !strings.Contains(hostUser[1], ":") {
+			// The line isn't quoted, because it may contain a password.
+			return nil, fmt.Errorf("%s:%d: invalid credentials (want host=username:password)", path, i+1)
+		}
+		userPass := strings.SplitN(hostUser[1], ":", 2)
+		host := strings.TrimSpace(hostUser[0])
+		creds[host] = webloop.Credentials{Username: strings.TrimSpace(userPass[0]), Password: userPass[1]}
+	}
+	return creds, nil
+}
+
+// newContext returns a copy of baseContext that reports to metrics.
+func newContext(metrics webloop.Metrics) webloop.Context {
+	c := baseContext
+	c.Metrics = metrics
+	return c
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sourcegraph/webloop"
+)
+
+func TestReadCredentials(t *testing.T) {
+	f, err := ioutil.TempFile("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`# Staging
+staging.internal=user:s3,cr=t:x
+
+staging.internal:8443 = other:pass
+`)
+	f.Close()
+
+	creds, err := readCredentials(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]webloop.Credentials{
+		"staging.internal":      {Username: "user", Password: "s3,cr=t:x"},
+		"staging.internal:8443": {Username: "other", Password: "pass"},
+	}
+	if !reflect.DeepEqual(creds, want) {
+		t.Errorf("want %+v, got %+v", want, creds)
+	}
+
+	if err := ioutil.WriteFile(f.Name(), []byte("staging.internal=secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = readCredentials(f.Name())
+	if err == nil || !strings.Contains(err.Error(), f.Name()+":1:") || strings.Contains(err.Error(), "secret") {
+		t.Errorf("want error with the line number but not the line, got %v", err)
+	}
+}
+
+func TestProxyFromFlags(t *testing.T) {
+	env := &webloop.ProxySettings{HTTP: "http://env:3128", Bypass: []string{"localhost"}}
+	tests := []struct {
+		proxy, noProxy string
+		env            *webloop.ProxySettings
+		want           *webloop.ProxySettings
+		wantErr        string // substring of the error
+	}{
+		{want: nil},
+		{env: env, want: env},
+		{proxy: "none", env: env, want: &webloop.ProxySettings{}},
+		{proxy: "http://p:3128", env: env, want: &webloop.ProxySettings{HTTP: "http://p:3128", HTTPS: "http://p:3128"}},
+		{proxy: "socks5://s:1080", noProxy: "a,.b", want: &webloop.ProxySettings{SOCKS: "socks5://s:1080", Bypass: []string{"a", ".b"}}},
+		{noProxy: "a", env: &webloop.ProxySettings{HTTP: "http://env:3128"}, want: &webloop.ProxySettings{HTTP: "http://env:3128", Bypass: []string{"a"}}},
+		{noProxy: "a", wantErr: "-no-proxy requires -proxy"},
+		{proxy: "http://wpad.internal/proxy.pac", wantErr: "PAC"},
+		{proxy: "ftp://p:21", wantErr: "want an http://"},
+		{proxy: "p:3128", wantErr: "invalid -proxy"},
+	}
+	for _, test := range tests {
+		got, err := proxyFromFlags(test.proxy, test.noProxy, test.env)
+		if test.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("-proxy=%q -no-proxy=%q: want error containing %q, got %v", test.proxy, test.noProxy, test.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("-proxy=%q -no-proxy=%q: %s", test.proxy, test.noProxy, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("-proxy=%q -no-proxy=%q: want %+v, got %+v", test.proxy, test.noProxy, test.want, got)
+		}
+	}
+}
+
+func TestCheckRouteFlags(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr bool
+	}{
+		{args: []string{"-redirect-prefixes=/static", "-proxy-prefixes=/api"}},
+		{args: []string{"-config=config.yaml"}},
+		{args: []string{"-config=config.yaml", "-redirect-prefixes=/static"}, wantErr: true},
+		{args: []string{"-proxy-prefixes=", "-config=config.yaml"}, wantErr: true},
+	}
+	for _, test := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.String("config", "", "")
+		fs.String("redirect-prefixes", "/static", "")
+		fs.String("proxy-prefixes", "", "")
+		if err := fs.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkRouteFlags(fs); (err != nil) != test.wantErr {
+			t.Errorf("%q: want error %v, got %v", test.args, test.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/sourcegraph/webloop"
+)
+
+// archiveMain runs the archive subcommand with the given command-line
+// arguments (after "archive").
+func archiveMain(args []string) {
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	outputDir := fs.String("out", "archive", "output directory for archives")
+	format := fs.String("format", "mhtml", "archive format: \"mhtml\" or \"html\" (a single HTML file with resources embedded as data URIs)")
+	input := fs.String("urls", "", "file listing URLs to archive, one per line (\"-\" for stdin)")
+	idle := fs.Duration("idle", 500*time.Millisecond, "how long a page's network activity must be idle before it is archived")
+	waitTimeout := fs.Duration("wait", 10*time.Second, "timeout for a page's network activity to become idle (the page is archived anyway)")
+	display := fs.String("display", "auto", displayUsage)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintf(os.Stderr, "webloop-crawl archive saves pages exactly as rendered, with their resources,\n")
+		fmt.Fprintf(os.Stderr, "to one file per page.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\n")
+		fmt.Fprintf(os.Stderr, "\twebloop-crawl archive [options] [url...]\n\n")
+		fmt.Fprintf(os.Stderr, "The options are:\n\n")
+		fs.PrintDefaults()
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintf(os.Stderr, "Example usage:\n\n")
+		fmt.Fprintf(os.Stderr, "\tTo archive the pages listed in urls.txt as MHTML files in ./archive:\n")
+		fmt.Fprintf(os.Stderr, "\t    $ webloop-crawl archive -urls=urls.txt\n\n")
+		fmt.Fprintf(os.Stderr, "\tThe page at http://example.com/a/b is written to\n")
+		fmt.Fprintf(os.Stderr, "\tarchive/example.com_a_b-<hash>.mhtml, where <hash> is a hash of the URL.\n\n")
+		printDisplayNotes()
+		os.Exit(1)
+	}
+	fs.Parse(args)
+
+	log := log.New(os.Stderr, "", 0)
+
+	switch webloop.ArchiveFormat(*format) {
+	case webloop.ArchiveMHTML, webloop.ArchiveSingleFile:
+	default:
+		log.Fatalf("Unknown archive format %q (must be %q or %q)", *format, webloop.ArchiveMHTML, webloop.ArchiveSingleFile)
+	}
+	urls := fs.Args()
+	if *input != "" {
+		more, err := readURLs(*input)
+		if err != nil {
+			log.Fatalf("Reading URLs: %s", err)
+		}
+		urls = append(urls, more...)
+	}
+	if len(urls) == 0 {
+		fs.Usage()
+	}
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	stopDisplay := setupDisplay(*display, log)
+	defer stopDisplay()
+
+	webloop.Start()
+	view := webloop.New().NewView()
+	defer view.Close()
+
+	var failed int
+	for _, u := range urls {
+		name := filepath.Join(*outputDir, archiveName(u, *format))
+		if err := archive(view, u, name, webloop.ArchiveFormat(*format), *idle, *waitTimeout, log); err != nil {
+			log.Printf("Failed to archive %s: %s", u, err)
+			failed++
+			continue
+		}
+		log.Printf("Archived %s to %s", u, name)
+	}
+	log.Printf("Archived %d pages (%d failed) into %s", len(urls)-failed, failed, *outputDir)
+	if failed > 0 {
+		view.Close()
+		stopDisplay()
+		os.Exit(2)
+	}
+}
+
+// archive loads the page at u in view, waits for its network activity to be
+// idle, and saves it to the file name.
+func archive(view *webloop.View, u, name string, format webloop.ArchiveFormat, idle, timeout time.Duration, log *log.Logger) error {
+	view.Open(u)
+	if err := view.Wait(); err != nil {
+		return err
+	}
+	if err := view.WaitForNetworkIdle(idle, 0, timeout); err == webloop.ErrWaitTimeout {
+		log.Printf("Network activity of %s did not become idle within %s; archiving it anyway", u, timeout)
+	} else if err != nil {
+		return err
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := view.SaveArchive(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// maxArchiveNameLength is the maximum length of the part of an archive's file
+// name made from its URL.
+const maxArchiveNameLength = 200
+
+// archiveName returns the file name for the archive of the page at rawurl,
+// made from its host, path and query. Because characters that aren't allowed
+// in file names are replaced (so that "/a/b" and "/a_b" both become "_a_b") and
+// long names are truncated, the name ends with a hash of rawurl.
+func archiveName(rawurl, format string) string {
+	name := rawurl
+	if u, err := url.Parse(rawurl); err == nil {
+		name = u.Host + strings.TrimSuffix(u.Path, "/")
+		if u.RawQuery != "" {
+			name += "?" + u.RawQuery
+		}
+	}
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, name)
+	if len(name) > maxArchiveNameLength {
+		name = name[:maxArchiveNameLength]
+	}
+	sum := sha1.Sum([]byte(rawurl))
+	return name + "-" + hex.EncodeToString(sum[:4]) + "." + format
+}
+
+// readURLs reads the URLs listed one per line in the file name (or stdin, if
+// name is "-"), skipping blank lines and lines starting with "#".
+func readURLs(name string) ([]string, error) {
+	f := os.Stdin
+	if name != "-" {
+		var err error
+		if f, err = os.Open(name); err != nil {
+			return nil, err
+		}
+		defer f.Close()
+	}
+	var urls []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if line := strings.TrimSpace(s.Text()); line != "" && !strings.HasPrefix(line, "#") {
+			urls = append(urls, line)
+		}
+	}
+	return urls, s.Err()
+}
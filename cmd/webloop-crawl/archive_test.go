@@ -0,0 +1,59 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestArchiveName(t *testing.T) {
+	tests := map[string]string{
+		"http://example.com/a/b":                         `^example\.com_a_b-[0-9a-f]{8}\.mhtml$`,
+		"http://example.com/a/b/":                        `^example\.com_a_b-[0-9a-f]{8}\.mhtml$`,
+		"http://example.com/?q=x y":                      `^example\.com_q_x_y-[0-9a-f]{8}\.mhtml$`,
+		"http://example.com/" + strings.Repeat("x", 300): `^example\.com_x{188}-[0-9a-f]{8}\.mhtml$`,
+	}
+	for rawurl, pattern := range tests {
+		if got := archiveName(rawurl, "mhtml"); !regexp.MustCompile(pattern).MatchString(got) {
+			t.Errorf("%s: want name matching %s, got %q", rawurl, pattern, got)
+		}
+	}
+
+	// URLs whose names would otherwise be the same get different names.
+	names := map[string]string{}
+	for _, u := range []string{"http://example.com/a/b", "http://example.com/a_b", "http://example.com/a/b/", "https://example.com/a/b"} {
+		name := archiveName(u, "html")
+		if other, ok := names[name]; ok {
+			t.Errorf("%s and %s have the same name %q", other, u, name)
+		}
+		names[name] = u
+	}
+	if a, b := archiveName("http://example.com/a", "html"), archiveName("http://example.com/a", "html"); a != b {
+		t.Errorf("want the same name for the same URL, got %q and %q", a, b)
+	}
+}
+
+func TestReadURLs(t *testing.T) {
+	f, err := ioutil.TempFile("", "urls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# Pages\nhttp://example.com/a\n\n  http://example.com/b  \n#http://example.com/c\n")
+	f.Close()
+
+	urls, err := readURLs(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://example.com/a", "http://example.com/b"}; !reflect.DeepEqual(urls, want) {
+		t.Errorf("want %q, got %q", want, urls)
+	}
+
+	if _, err := readURLs(f.Name() + "-missing"); err == nil {
+		t.Error("want error for missing file")
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sourcegraph/webloop"
+)
+
+var outputDir = flag.String("out", "static", "output directory for rendered pages and manifest.json")
+var sitemaps = flag.String("sitemap", "", "comma-separated list of sitemap.xml URLs to crawl")
+var maxDepth = flag.Int("depth", 5, "maximum number of links to follow from a seed URL (-1 for unlimited)")
+var maxPages = flag.Int("max-pages", 0, "maximum number of pages to crawl (0 for unlimited)")
+var include = flag.String("include", "", "comma-separated list of regexps; only crawl discovered URLs matching one of them")
+var exclude = flag.String("exclude", "", "comma-separated list of regexps; don't crawl discovered URLs matching any of them")
+var ignoreRobots = flag.Bool("ignore-robots", false, "crawl pages disallowed by robots.txt")
+var waitTimeout = flag.Duration("wait", time.Second*3, "timeout for pages to become ready")
+var ready = flag.String("ready", "", "JavaScript expression that is true when a page is ready (default window.$renderStaticReady)")
+var returnUnfinishedPages = flag.Bool("unfinished", false, "write unfinished pages at wait timeout (instead of recording an error)")
+var removeScripts = flag.Bool("remove-scripts", false, "remove <script> tags")
+var writeSitemap = flag.Bool("write-sitemap", false, "write sitemap.xml (with canonical URLs and hreflang alternates) to the output directory")
+var sitemapBase = flag.String("sitemap-base", "", "URL at which the output directory is served, for sitemap index files (default origin of first seed)")
+var display = flag.String("display", "auto", displayUsage)
+
+// displayUsage is the usage of the -display flag, which both subcommands have.
+const displayUsage = "display for WebKit: \"existing\" ($DISPLAY), \"xvfb\" (start an Xvfb) or \"auto\" (existing if set, otherwise xvfb)"
+
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "archive" {
+		archiveMain(os.Args[2:])
+		return
+	}
+
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintf(os.Stderr, "webloop-crawl pre-renders a dynamic JavaScript application to static HTML\n")
+		fmt.Fprintf(os.Stderr, "files. It uses a headless WebKit browser instance to render each page and\n")
+		fmt.Fprintf(os.Stderr, "follows same-origin links in the rendered pages.\n\n")
+		fmt.Fprintf(os.Stderr, "Usage:\n\n")
+		fmt.Fprintf(os.Stderr, "\twebloop-crawl [options] [seed-url...]\n")
+		fmt.Fprintf(os.Stderr, "\twebloop-crawl archive [options] [url...]\n\n")
+		fmt.Fprintf(os.Stderr, "The options are:\n\n")
+		flag.PrintDefaults()
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintln(os.Stderr)
+		fmt.Fprintf(os.Stderr, "Example usage:\n\n")
+		fmt.Fprintf(os.Stderr, "\tTo render the site at http://localhost:3000 to files in ./static:\n")
+		fmt.Fprintf(os.Stderr, "\t    $ webloop-crawl -out=static http://localhost:3000/\n\n")
+		fmt.Fprintf(os.Stderr, "\tThe page at http://localhost:3000/a/b is written to static/a/b/index.html,\n")
+		fmt.Fprintf(os.Stderr, "\tand a list of all crawled pages is written to static/manifest.json.\n\n")
+		fmt.Fprintf(os.Stderr, "\tTo archive pages as rendered (as MHTML or single HTML files), run\n")
+		fmt.Fprintf(os.Stderr, "\twebloop-crawl archive -h for more info.\n\n")
+		printDisplayNotes()
+		os.Exit(1)
+	}
+	flag.Parse()
+
+	log := log.New(os.Stderr, "", 0)
+
+	c := &webloop.Crawler{
+		Seeds:                 flag.Args(),
+		Sitemaps:              split(*sitemaps),
+		OutputDir:             *outputDir,
+		MaxDepth:              *maxDepth,
+		MaxPages:              *maxPages,
+		Include:               compile(split(*include), log),
+		Exclude:               compile(split(*exclude), log),
+		IgnoreRobots:          *ignoreRobots,
+		WaitTimeout:           *waitTimeout,
+		ReadyExpression:       *ready,
+		ReturnUnfinishedPages: *returnUnfinishedPages,
+		RemoveScripts:         *removeScripts,
+		WriteSitemap:          *writeSitemap,
+		SitemapBaseURL:        *sitemapBase,
+		Log:                   log,
+	}
+	if len(c.Seeds) == 0 && len(c.Sitemaps) == 0 {
+		flag.Usage()
+	}
+
+	stopDisplay := setupDisplay(*display, log)
+	defer stopDisplay()
+
+	m, err := c.Crawl()
+	if err != nil {
+		log.Fatalf("Crawl: %s", err)
+	}
+	var failed int
+	for _, p := range m.Pages {
+		if p.Error != "" {
+			failed++
+		}
+	}
+	log.Printf("Crawled %d pages (%d failed) into %s", len(m.Pages), failed, *outputDir)
+	if failed > 0 {
+		stopDisplay()
+		os.Exit(2)
+	}
+}
+
+// printDisplayNotes prints the usage notes about the display that WebKit
+// needs.
+func printDisplayNotes() {
+	fmt.Fprintf(os.Stderr, "Notes:\n\n")
+	fmt.Fprintf(os.Stderr, "\tWebKit needs a display, even though it runs headless. By default, the\n")
+	fmt.Fprintf(os.Stderr, "\texisting $DISPLAY is used if set; otherwise, an Xvfb (which must be\n")
+	fmt.Fprintf(os.Stderr, "\tinstalled) is started and stopped with webloop-crawl. See\n")
+	fmt.Fprintf(os.Stderr, "\thttps://sourcegraph.com/github.com/sourcegraph/webloop/readme for more info.\n")
+	fmt.Fprintln(os.Stderr)
+}
+
+// setupDisplay sets up the display for WebKit in the given mode (see
+// webloop.SetupDisplay), exiting if it can't. It returns a func that stops the
+// Xvfb that it started, if any.
+func setupDisplay(mode string, log *log.Logger) (stop func()) {
+	xvfb, err := webloop.SetupDisplay(webloop.DisplayMode(mode))
+	if err != nil {
+		log.Fatalf("Setting up display: %s", err)
+	}
+	if xvfb == nil {
+		return func() {}
+	}
+	log.Printf("Started Xvfb on display %s", xvfb.Display)
+	stopping := make(chan struct{})
+	go func() {
+		select {
+		case <-xvfb.Done():
+			log.Fatalf("Xvfb exited unexpectedly: %v", xvfb.Err())
+		case <-stopping:
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stopping)
+			xvfb.Close()
+		})
+	}
+}
+
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, ",")
+}
+
+func compile(exprs []string, log *log.Logger) []*regexp.Regexp {
+	var res []*regexp.Regexp
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			log.Fatalf("Invalid regexp %q: %s", expr, err)
+		}
+		res = append(res, re)
+	}
+	return res
+}
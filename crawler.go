@@ -0,0 +1,464 @@
+package webloop
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Crawler pre-renders a site to static HTML files. Starting from seed URLs
+// (and the URLs listed in sitemaps), it renders each page in a View, waits for
+// it to become ready in the same way as StaticRenderer, and follows the
+// same-origin links in the rendered page.
+type Crawler struct {
+	// Context is the WebLoop context to create views in.
+	Context Context
+
+	// Seeds are the URLs to start crawling at. Only links to the same origins
+	// (scheme, host and port) as the seeds are followed.
+	Seeds []string
+
+	// Sitemaps are the URLs of sitemap.xml files (or sitemap index files)
+	// whose URLs are crawled in addition to Seeds.
+	Sitemaps []string
+
+	// OutputDir is the directory to write rendered pages to. The page at
+	// http://example.com/a/b is written to OutputDir/a/b/index.html. URLs
+	// that would be written to the same file (such as /a/b and /a/b/) are
+	// only crawled once. A manifest of all crawled pages is written to
+	// OutputDir/manifest.json.
+	OutputDir string
+
+	// MaxDepth is the maximum number of links to follow from a seed. If zero,
+	// only the seeds are crawled. If negative, there is no limit.
+	MaxDepth int
+
+	// MaxPages is the maximum number of pages to crawl. If zero, there is no
+	// limit.
+	MaxPages int
+
+	// Include, if non-empty, restricts crawling to the discovered URLs that
+	// match at least one of its patterns. Seeds are always crawled.
+	Include []*regexp.Regexp
+
+	// Exclude prevents crawling discovered URLs that match any of its
+	// patterns.
+	Exclude []*regexp.Regexp
+
+	// IgnoreRobots is whether to crawl pages that robots.txt disallows.
+	IgnoreRobots bool
+
+	// WaitTimeout, ReadyExpression and ReturnUnfinishedPages have the same
+	// meaning as in StaticRenderer. Pages that are unfinished and not returned
+	// are recorded as errors in the manifest.
+	WaitTimeout           time.Duration
+	ReadyExpression       string
+	ReturnUnfinishedPages bool
+
+	// RemoveScripts has the same meaning as in StaticRenderer.
+	RemoveScripts bool
+
+	// WriteSitemap is whether to write a sitemap.xml file (split into
+	// multiple files with a sitemap index if necessary) to OutputDir,
+	// listing each rendered page's canonical URL, last-modified hint and
+	// hreflang alternates from its rendered DOM.
+	WriteSitemap bool
+
+	// SitemapBaseURL is the URL at which OutputDir is served, used to refer
+	// to split sitemap files from the sitemap index. If empty, the origin of
+	// the first seed is used.
+	SitemapBaseURL string
+
+	// Log is the logger to use for log messages. If nil, there is no log
+	// output.
+	Log *log.Logger
+}
+
+// Manifest lists the pages that a Crawler crawled.
+type Manifest struct {
+	Pages []*ManifestPage `json:"pages"`
+}
+
+// ManifestPage describes a crawled page.
+type ManifestPage struct {
+	// URL is the URL of the page.
+	URL string `json:"url"`
+
+	// Path is the path of the rendered HTML file, relative to the output
+	// directory. It is empty if the page was not rendered.
+	Path string `json:"path,omitempty"`
+
+	// Depth is the number of links followed from a seed to reach the page.
+	Depth int `json:"depth"`
+
+	// Unfinished is whether the page did not become ready within the wait
+	// timeout.
+	Unfinished bool `json:"unfinished,omitempty"`
+
+	// Error describes why the page could not be rendered, if it wasn't.
+	Error string `json:"error,omitempty"`
+
+	// Sitemap is the page's sitemap entry, if WriteSitemap is set.
+	Sitemap *SitemapEntry `json:"sitemap,omitempty"`
+}
+
+// robotsUserAgent is the user agent whose robots.txt rules the Crawler obeys.
+const robotsUserAgent = "WebLoop"
+
+// Crawl crawls the site and writes the rendered pages and manifest to
+// OutputDir. Errors rendering individual pages are recorded in the manifest
+// and do not stop the crawl.
+func (c *Crawler) Crawl() (*Manifest, error) {
+	seeds := c.Seeds
+	for _, sitemap := range c.Sitemaps {
+		urls, err := fetchSitemap(sitemap)
+		if err != nil {
+			return nil, err
+		}
+		seeds = append(seeds, urls...)
+	}
+	if len(seeds) == 0 {
+		return nil, errors.New("no seed URLs to crawl")
+	}
+
+	type queued struct {
+		url   *url.URL
+		depth int
+	}
+	var (
+		queue   []queued
+		seen    = map[string]bool{}   // pageKeys of queued URLs
+		written = map[string]string{} // output paths of rendered pages -> URLs
+		origins = map[string]bool{}
+		robots  = map[string]robotsRules{}
+	)
+	for _, seed := range seeds {
+		u, err := url.Parse(seed)
+		if err != nil || !u.IsAbs() {
+			return nil, fmt.Errorf("invalid seed URL %q", seed)
+		}
+		u.Fragment = ""
+		origins[origin(u)] = true
+		if !seen[pageKey(u)] {
+			seen[pageKey(u)] = true
+			queue = append(queue, queued{url: u})
+		}
+	}
+
+	if err := os.MkdirAll(c.OutputDir, 0755); err != nil {
+		return nil, err
+	}
+
+	Start()
+	view := c.Context.NewView()
+	defer func() { view.Close() }()
+
+	m := &Manifest{}
+	for len(queue) > 0 && (c.MaxPages == 0 || len(m.Pages) < c.MaxPages) {
+		q := queue[0]
+		queue = queue[1:]
+
+		if !c.IgnoreRobots {
+			rules, present := robots[origin(q.url)]
+			if !present {
+				rules = c.fetchRobots(q.url)
+				robots[origin(q.url)] = rules
+			}
+			if !rules.allowed(q.url.RequestURI()) {
+				c.logf("Skipping URL disallowed by robots.txt: %s", q.url)
+				continue
+			}
+		}
+
+		page := &ManifestPage{URL: q.url.String(), Depth: q.depth}
+		m.Pages = append(m.Pages, page)
+		c.logf("Rendering page at URL: %s", q.url)
+		if view.Crashed() {
+			view.Close()
+			view = c.Context.NewView()
+		}
+		html, links, err := c.render(view, page)
+		if err != nil && view.Crashed() {
+			c.logf("Web process crashed while rendering page at URL %s; restarting it and retrying", q.url)
+			view.Close()
+			view = c.Context.NewView()
+			html, links, err = c.render(view, page)
+		}
+		if err != nil {
+			c.logf("Failed to render page at URL %s: %s", q.url, err)
+			page.Error = err.Error()
+			continue
+		}
+		outPath := outputPath(q.url)
+		if other, ok := written[outPath]; ok {
+			// Pages on different origins can have the same path.
+			c.logf("Not writing page at URL %s, because %s was already written to %s", q.url, other, outPath)
+			page.Error = fmt.Sprintf("output file %s is already used by %s", outPath, other)
+			continue
+		}
+		written[outPath] = page.URL
+		page.Path = outPath
+		if err := writeFile(filepath.Join(c.OutputDir, filepath.FromSlash(page.Path)), []byte(html)); err != nil {
+			return nil, err
+		}
+
+		if c.MaxDepth >= 0 && q.depth >= c.MaxDepth {
+			continue
+		}
+		for _, link := range links {
+			u, err := q.url.Parse(link)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				continue
+			}
+			u.Fragment = ""
+			if seen[pageKey(u)] || !origins[origin(u)] || !c.follow(u.String()) {
+				continue
+			}
+			seen[pageKey(u)] = true
+			queue = append(queue, queued{url: u, depth: q.depth + 1})
+		}
+	}
+
+	if c.WriteSitemap {
+		if err := c.writeSitemap(m, seeds[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFile(filepath.Join(c.OutputDir, "manifest.json"), data); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// extractLinksScript returns the absolute URLs of all links in the page.
+const extractLinksScript = `Array.prototype.map.call(document.querySelectorAll("a[href]"), function(a) { return a.href; })`
+
+// render renders page in view, returning the rendered HTML and the URLs of
+// the links in the page.
+func (c *Crawler) render(view *View, page *ManifestPage) (html string, links []string, err error) {
+	view.Open(page.URL)
+	if err := view.Wait(); err != nil {
+		return "", nil, err
+	}
+
+	readyExpr := c.ReadyExpression
+	if readyExpr == "" {
+		readyExpr = DefaultReadyExpression
+	}
+	ready, err := waitReady(context.Background(), trace.NewNoopTracerProvider().Tracer(""), view, readyExpr, c.WaitTimeout)
+	if err != nil {
+		return "", nil, fmt.Errorf("error checking %s: %s", readyExpr, err)
+	}
+	if !ready {
+		if !c.ReturnUnfinishedPages {
+			return "", nil, fmt.Errorf("page did not set %s within timeout %s", readyExpr, c.WaitTimeout)
+		}
+		page.Unfinished = true
+	}
+
+	result, err := view.EvaluateJavaScript("document.documentElement.outerHTML")
+	if err != nil {
+		return "", nil, err
+	}
+	html, _ = result.(string)
+	if c.RemoveScripts {
+		html = strings.Replace(html, "<script", `<script type="text/disabled"`, -1)
+	}
+
+	if c.WriteSitemap {
+		if page.Sitemap, err = view.sitemapEntry(); err != nil {
+			return "", nil, err
+		}
+	}
+
+	result, err = view.EvaluateJavaScript(extractLinksScript)
+	if err != nil {
+		return "", nil, err
+	}
+	if hrefs, ok := result.([]interface{}); ok {
+		for _, href := range hrefs {
+			if href, ok := href.(string); ok {
+				links = append(links, href)
+			}
+		}
+	}
+	return html, links, nil
+}
+
+// writeSitemap writes the sitemap entries of the rendered pages in m, each
+// canonical URL listed once, to OutputDir.
+func (c *Crawler) writeSitemap(m *Manifest, firstSeed string) error {
+	var (
+		entries []*SitemapEntry
+		seen    = map[string]bool{}
+	)
+	for _, p := range m.Pages {
+		if p.Sitemap != nil && !seen[p.Sitemap.Loc] {
+			seen[p.Sitemap.Loc] = true
+			entries = append(entries, p.Sitemap)
+		}
+	}
+
+	baseURL := c.SitemapBaseURL
+	if baseURL == "" {
+		u, _ := url.Parse(firstSeed)
+		baseURL = origin(u)
+	}
+	return WriteSitemaps(c.OutputDir, baseURL, entries)
+}
+
+// follow reports whether a discovered link to rawurl should be crawled,
+// according to the Include and Exclude patterns.
+func (c *Crawler) follow(rawurl string) bool {
+	for _, re := range c.Exclude {
+		if re.MatchString(rawurl) {
+			return false
+		}
+	}
+	if len(c.Include) == 0 {
+		return true
+	}
+	for _, re := range c.Include {
+		if re.MatchString(rawurl) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchRobots fetches and parses the robots.txt file for u's origin. If it
+// can't be fetched, all URLs are allowed.
+func (c *Crawler) fetchRobots(u *url.URL) robotsRules {
+	robotsURL := origin(u) + "/robots.txt"
+	resp, err := fetchClient.Get(robotsURL)
+	if err != nil {
+		c.logf("Failed to fetch %s (allowing all URLs): %s", robotsURL, err)
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+	rules, err := parseRobots(resp.Body, robotsUserAgent)
+	if err != nil {
+		c.logf("Failed to read %s (allowing all URLs): %s", robotsURL, err)
+		return nil
+	}
+	return rules
+}
+
+func (c *Crawler) logf(msg string, v ...interface{}) {
+	if c.Log != nil {
+		c.Log.Printf(msg, v...)
+	}
+}
+
+// origin returns the scheme, host and port of u, as in "http://example.com".
+func origin(u *url.URL) string {
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// pageKey returns a key that is the same for URLs that are the same page, as
+// far as the Crawler is concerned: those on the same origin that are written
+// to the same output file.
+func pageKey(u *url.URL) string {
+	return origin(u) + "/" + outputPath(u)
+}
+
+// outputPath returns the slash-separated path, relative to the output
+// directory, of the file that the rendered page at u is written to. The query
+// string, if any, is escaped and appended to the last path element.
+func outputPath(u *url.URL) string {
+	p := path.Clean("/" + u.Path)
+	if u.RawQuery != "" {
+		p += url.PathEscape("?" + u.RawQuery)
+	}
+	return strings.TrimPrefix(path.Join(p, "index.html"), "/")
+}
+
+func writeFile(name string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name, data, 0644)
+}
+
+// fetchTimeout is the timeout for the Crawler's requests for robots.txt and
+// sitemap files.
+const fetchTimeout = 30 * time.Second
+
+// fetchClient is the HTTP client for the Crawler's requests for robots.txt and
+// sitemap files.
+var fetchClient = &http.Client{Timeout: fetchTimeout}
+
+// maxSitemapDepth is how deeply sitemap indexes that list other sitemap
+// indexes are followed.
+const maxSitemapDepth = 5
+
+// fetchSitemap returns the page URLs listed in the sitemap (or, recursively,
+// the sitemaps listed in the sitemap index) at sitemapURL. Each sitemap is
+// fetched at most once, so cycles of sitemap indexes are ignored.
+func fetchSitemap(sitemapURL string) ([]string, error) {
+	return fetchSitemaps(sitemapURL, map[string]bool{}, 0)
+}
+
+// fetchSitemaps is fetchSitemap for a sitemap at the given depth of sitemap
+// indexes, skipping those in seen (and adding sitemapURL to it).
+func fetchSitemaps(sitemapURL string, seen map[string]bool, depth int) ([]string, error) {
+	seen[sitemapURL] = true
+	resp, err := fetchClient.Get(sitemapURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching sitemap %s: HTTP %s", sitemapURL, resp.Status)
+	}
+
+	var doc struct {
+		XMLName  xml.Name
+		URLs     []string `xml:"url>loc"`
+		Sitemaps []string `xml:"sitemap>loc"`
+	}
+	if err := xml.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parsing sitemap %s: %s", sitemapURL, err)
+	}
+	var urls []string
+	for _, u := range doc.URLs {
+		urls = append(urls, strings.TrimSpace(u))
+	}
+	if len(doc.Sitemaps) > 0 && depth >= maxSitemapDepth {
+		return nil, fmt.Errorf("sitemap %s: sitemap indexes nested more than %d deep", sitemapURL, maxSitemapDepth)
+	}
+	for _, sm := range doc.Sitemaps {
+		sm = strings.TrimSpace(sm)
+		if seen[sm] {
+			continue
+		}
+		more, err := fetchSitemaps(sm, seen, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		urls = append(urls, more...)
+	}
+	return urls, nil
+}
@@ -0,0 +1,125 @@
+package webloop
+
+import (
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+func TestOutputPath(t *testing.T) {
+	tests := map[string]string{
+		"http://example.com":             "index.html",
+		"http://example.com/":            "index.html",
+		"http://example.com/a/b":         "a/b/index.html",
+		"http://example.com/a/b/":        "a/b/index.html",
+		"http://example.com/../a":        "a/index.html",
+		"http://example.com/search?q=a/": "search%3Fq=a%2F/index.html",
+	}
+	for rawurl, want := range tests {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := outputPath(u); want != got {
+			t.Errorf("%s: want %q, got %q", rawurl, want, got)
+		}
+	}
+}
+
+func TestPageKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"http://example.com/a", "http://example.com/a/", true},
+		{"http://example.com/a", "http://example.com/a/../a", true},
+		{"http://example.com/a", "http://example.com/b", false},
+		{"http://example.com/a", "http://example.com/a?x=1", false},
+		{"http://example.com/a", "https://example.com/a", false},
+	}
+	for _, test := range tests {
+		a, err := url.Parse(test.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := url.Parse(test.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if same := pageKey(a) == pageKey(b); same != test.same {
+			t.Errorf("%s and %s: want same == %v, got %v", test.a, test.b, test.same, same)
+		}
+	}
+}
+
+func TestFetchSitemap(t *testing.T) {
+	setup()
+	defer teardown()
+
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>` + server.URL + `/sitemap1.xml</loc></sitemap>
+</sitemapindex>`))
+	})
+	mux.HandleFunc("/sitemap1.xml", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://example.com/</loc></url>
+  <url><loc>http://example.com/about</loc><lastmod>2014-01-01</lastmod></url>
+  <url>
+    <loc>
+      http://example.com/contact
+    </loc>
+  </url>
+</urlset>`))
+	})
+
+	urls, err := fetchSitemap(server.URL + "/sitemap.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://example.com/", "http://example.com/about", "http://example.com/contact"}; !reflect.DeepEqual(want, urls) {
+		t.Errorf("want %v, got %v", want, urls)
+	}
+}
+
+func TestFetchSitemap_nestedIndexes(t *testing.T) {
+	setup()
+	defer teardown()
+
+	// index1 lists index2, which lists index1 again and a sitemap.
+	index := func(locs ...string) string {
+		s := `<?xml version="1.0" encoding="UTF-8"?><sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">`
+		for _, loc := range locs {
+			s += `<sitemap><loc>` + server.URL + loc + `</loc></sitemap>`
+		}
+		return s + `</sitemapindex>`
+	}
+	mux.HandleFunc("/index1.xml", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(index("/index2.xml")))
+	})
+	mux.HandleFunc("/index2.xml", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(index("/index1.xml", "/sitemap.xml")))
+	})
+	mux.HandleFunc("/sitemap.xml", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9"><url><loc>http://example.com/</loc></url></urlset>`))
+	})
+	// deep/N lists deep/N+1, without end.
+	mux.HandleFunc("/deep/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(index(r.URL.Path + "x")))
+	})
+
+	urls, err := fetchSitemap(server.URL + "/index1.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"http://example.com/"}; !reflect.DeepEqual(want, urls) {
+		t.Errorf("want %v, got %v", want, urls)
+	}
+
+	if _, err := fetchSitemap(server.URL + "/deep/"); err == nil {
+		t.Error("want error for too deeply nested sitemap indexes")
+	}
+}
@@ -0,0 +1,154 @@
+package webloop
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DisplayMode says how to find a display for GTK+ to connect to.
+type DisplayMode string
+
+const (
+	// DisplayAuto uses the existing display if $DISPLAY or $WAYLAND_DISPLAY
+	// is set, and starts an Xvfb otherwise.
+	DisplayAuto DisplayMode = "auto"
+
+	// DisplayExisting uses the existing display, failing if neither
+	// $DISPLAY nor $WAYLAND_DISPLAY is set.
+	DisplayExisting DisplayMode = "existing"
+
+	// DisplayXvfb always starts an Xvfb.
+	DisplayXvfb DisplayMode = "xvfb"
+)
+
+// ErrNoDisplay indicates that there is no existing display to connect to.
+var ErrNoDisplay = errors.New("no display ($DISPLAY and $WAYLAND_DISPLAY are not set)")
+
+// xvfbStartTimeout is the maximum duration to wait for Xvfb to start.
+const xvfbStartTimeout = 10 * time.Second
+
+// SetupDisplay makes a display available to GTK+ according to mode. It must
+// be called before Start (or anything else that initializes GTK+). If it
+// starts an Xvfb, it sets $DISPLAY and returns the Xvfb, which the caller
+// should close when it no longer needs the display; otherwise, it returns a
+// nil Xvfb.
+func SetupDisplay(mode DisplayMode) (*Xvfb, error) {
+	switch mode {
+	case DisplayAuto, "":
+		if hasDisplay() {
+			return nil, nil
+		}
+	case DisplayExisting:
+		if !hasDisplay() {
+			return nil, ErrNoDisplay
+		}
+		return nil, nil
+	case DisplayXvfb:
+	default:
+		return nil, fmt.Errorf("unknown display mode %q (want %q, %q or %q)", mode, DisplayAuto, DisplayExisting, DisplayXvfb)
+	}
+
+	x, err := StartXvfb()
+	if err != nil {
+		return nil, err
+	}
+	// Unset $WAYLAND_DISPLAY so that GTK+ uses the Xvfb even if it
+	// prefers Wayland.
+	os.Setenv("DISPLAY", x.Display)
+	os.Unsetenv("WAYLAND_DISPLAY")
+	return x, nil
+}
+
+func hasDisplay() bool {
+	return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+}
+
+// Xvfb is a running Xvfb virtual X server.
+type Xvfb struct {
+	// Display is the X display name of the server, such as ":1".
+	Display string
+
+	cmd  *exec.Cmd
+	done chan struct{} // closed when the server exits
+	err  error         // the server's exit error, set before done is closed
+}
+
+// StartXvfb starts an Xvfb on an unused display number and waits for it to
+// accept connections. The Xvfb command must be in $PATH.
+func StartXvfb() (*Xvfb, error) {
+	// Xvfb writes the display number it chose to the -displayfd file
+	// descriptor once it is ready for connections.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	cmd := exec.Command("Xvfb", "-displayfd", "3", "-screen", "0", "1280x1024x24", "-nolisten", "tcp")
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{w}
+	err = startWithParent(cmd)
+	w.Close()
+	if err != nil {
+		return nil, fmt.Errorf("starting Xvfb: %s", err)
+	}
+
+	x := &Xvfb{cmd: cmd, done: make(chan struct{})}
+	go func() {
+		x.err = cmd.Wait()
+		close(x.done)
+	}()
+
+	display := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(r).ReadString('\n')
+		display <- strings.TrimSpace(line)
+	}()
+	select {
+	case n := <-display:
+		if n == "" {
+			// Xvfb exited (closing the pipe) before it was ready.
+			<-x.done
+			return nil, fmt.Errorf("Xvfb exited before accepting connections: %v", x.err)
+		}
+		x.Display = ":" + n
+		return x, nil
+	case <-time.After(xvfbStartTimeout):
+		x.Close()
+		return nil, fmt.Errorf("Xvfb did not accept connections within %s", xvfbStartTimeout)
+	}
+}
+
+// Done returns a channel that is closed when the Xvfb exits. Once the Xvfb
+// exits, GTK+ loses its display, so programs should treat an unexpected exit
+// as fatal.
+func (x *Xvfb) Done() <-chan struct{} {
+	return x.done
+}
+
+// Err returns the reason the Xvfb exited, once Done is closed.
+func (x *Xvfb) Err() error {
+	<-x.done
+	return x.err
+}
+
+// Close stops the Xvfb and waits for it to exit.
+func (x *Xvfb) Close() error {
+	select {
+	case <-x.done:
+		return nil
+	default:
+	}
+	x.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-x.done:
+	case <-time.After(5 * time.Second):
+		x.cmd.Process.Kill()
+		<-x.done
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package webloop
+
+import (
+	"os/exec"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+// startRequests are served by a goroutine that starts the processes for
+// startWithParent. It is locked to its OS thread and never exits, because
+// Linux sends a child its parent-death signal when the thread that forked it
+// exits, not when the process does.
+var (
+	startThreadOnce sync.Once
+	startRequests   = make(chan startRequest)
+)
+
+type startRequest struct {
+	cmd  *exec.Cmd
+	done chan<- error
+}
+
+// startWithParent starts cmd, making its process receive SIGTERM if this
+// process exits without stopping it (for example, because of log.Fatal).
+func startWithParent(cmd *exec.Cmd) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Pdeathsig: syscall.SIGTERM}
+	startThreadOnce.Do(func() {
+		go func() {
+			runtime.LockOSThread()
+			for req := range startRequests {
+				req.done <- req.cmd.Start()
+			}
+		}()
+	})
+	done := make(chan error, 1)
+	startRequests <- startRequest{cmd, done}
+	return <-done
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package webloop
+
+import "os/exec"
+
+// startWithParent starts cmd. On systems other than Linux, a child process
+// can't be made to exit with its parent.
+func startWithParent(cmd *exec.Cmd) error {
+	return cmd.Start()
+}
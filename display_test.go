@@ -0,0 +1,67 @@
+package webloop
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestSetupDisplay_existing(t *testing.T) {
+	defer setenv("DISPLAY", "")()
+	defer setenv("WAYLAND_DISPLAY", "")()
+
+	if _, err := SetupDisplay(DisplayExisting); err != ErrNoDisplay {
+		t.Errorf("with no display: want ErrNoDisplay, got %v", err)
+	}
+
+	os.Setenv("DISPLAY", ":123")
+	for _, mode := range []DisplayMode{DisplayExisting, DisplayAuto} {
+		x, err := SetupDisplay(mode)
+		if err != nil {
+			t.Errorf("%s: %s", mode, err)
+		}
+		if x != nil {
+			t.Errorf("%s: want no Xvfb to be started", mode)
+			x.Close()
+		}
+	}
+}
+
+func TestSetupDisplay_unknownMode(t *testing.T) {
+	if _, err := SetupDisplay("foo"); err == nil {
+		t.Error("want error for unknown mode")
+	}
+}
+
+func TestStartXvfb(t *testing.T) {
+	if _, err := exec.LookPath("Xvfb"); err != nil {
+		t.Skip("Xvfb not installed")
+	}
+	x, err := StartXvfb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if x.Display == "" || x.Display[0] != ':' {
+		t.Errorf("want display name like \":1\", got %q", x.Display)
+	}
+	x.Close()
+	select {
+	case <-x.Done():
+	default:
+		t.Error("want Xvfb to have exited after Close")
+	}
+}
+
+// setenv sets the environment variable key to value and returns a func that
+// restores its previous value.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
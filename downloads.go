@@ -0,0 +1,260 @@
+package webloop
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gotk3/gotk3/glib"
+)
+
+var (
+	// ErrDownloadRejected indicates that a download was rejected by the
+	// Context's OnDownload func (or because the Context has none).
+	ErrDownloadRejected = errors.New("download rejected")
+
+	// ErrDownloadFailed indicates that a download failed, for example
+	// because the connection was lost or the file couldn't be written.
+	ErrDownloadFailed = errors.New("download failed")
+)
+
+// DownloadPolicy is what to do with a file that a page downloads.
+type DownloadPolicy int
+
+const (
+	// DownloadReject cancels the download.
+	DownloadReject DownloadPolicy = iota
+
+	// DownloadToDirectory saves the file in the Context's DownloadDir,
+	// with its suggested filename (made unique if a file with that name
+	// already exists).
+	DownloadToDirectory
+
+	// DownloadToMemory reads the file into the Download's Data. WebKit
+	// can only save downloads to files, so the file is first saved in a
+	// temporary file named "webloop-download-*" in os.TempDir(), which is
+	// removed when the download finishes. If the process exits before
+	// then, the temporary file is left behind.
+	DownloadToMemory
+)
+
+// maxQueuedDownloads is the maximum number of downloads that a View keeps for
+// WaitForDownload. When more are started, the oldest ones are forgotten.
+const maxQueuedDownloads = 100
+
+// Download is a file that a page in a View downloads, for example when a link
+// to a file that WebKit can't display is followed or a response is sent with
+// "Content-Disposition: attachment".
+//
+// URI, SuggestedFilename, MIMEType and Size are set before the Context's
+// OnDownload func is called. Path, Data and Err are set before Done is closed.
+type Download struct {
+	// URI is the URI of the file.
+	URI string
+
+	// SuggestedFilename is the name that the server suggested for the file
+	// (or, if it didn't, one derived from its URI).
+	SuggestedFilename string
+
+	// MIMEType is the MIME type of the file.
+	MIMEType string
+
+	// Size is the size of the file in bytes, or 0 if it is unknown.
+	Size int64
+
+	// Path is the file in which the download was saved, for
+	// DownloadToDirectory.
+	Path string
+
+	// Data is the content of the file, for DownloadToMemory.
+	Data []byte
+
+	// Err is non-nil if the download was rejected (ErrDownloadRejected),
+	// failed (an error wrapping ErrDownloadFailed, with WebKit's
+	// description of the failure) or couldn't be saved.
+	Err error
+
+	done     chan struct{} // closed when the download finishes
+	finished bool          // whether done is closed; guarded by the View's mu
+}
+
+// Done returns a channel that is closed when the download finishes, whether or
+// not it succeeded.
+func (d *Download) Done() <-chan struct{} {
+	return d.done
+}
+
+var (
+	// downloadViews maps the webViewIDs of open Views to the Views, so that
+	// downloads (which WebKit reports for the whole web context) can be
+	// handled by the View that started them. It is only accessed on the
+	// GTK+ thread.
+	downloadViews = map[uintptr]*View{}
+
+	connectDownloadsOnce sync.Once
+)
+
+// connectDownloads makes the view handle the downloads that its pages start.
+// It is called on the GTK+ thread.
+func (v *View) connectDownloads() {
+	downloadViews[webViewID(v.WebView)] = v
+	connectDownloadsOnce.Do(func() {
+		webContext(v.WebView).Connect("download-started", func(_ *glib.Object, download *glib.Object) {
+			// Downloads that weren't started by a View (such as
+			// those started by other code using WebKit directly)
+			// are left alone.
+			if v := downloadViews[downloadWebViewID(download)]; v != nil {
+				v.downloadStarted(download)
+			}
+		})
+	})
+	v.WebView.Connect("decide-policy", func(_ *glib.Object, decision *glib.Object, decisionType int) bool {
+		if decisionType == policyDecisionTypeResponse && isDownloadResponse(decision) {
+			downloadResponse(decision)
+			return true
+		}
+		return false
+	})
+}
+
+// downloadStarted is called on the GTK+ thread when the view starts the
+// WebKitDownload download.
+func (v *View) downloadStarted(download *glib.Object) {
+	d := &Download{done: make(chan struct{})}
+	d.URI, _, _ = downloadInfo(download)
+	v.mu.Lock()
+	if len(v.downloads) == maxQueuedDownloads {
+		v.downloads = v.downloads[1:]
+	}
+	v.downloads = append(v.downloads, d)
+	v.mu.Unlock()
+
+	var (
+		policy DownloadPolicy
+		path   string
+		err    error
+	)
+	// WebKit emits "decide-destination" once the response is received,
+	// "failed" (if the download failed or was cancelled) and then
+	// "finished".
+	download.Connect("decide-destination", func(_ *glib.Object, suggestedFilename string) bool {
+		d.URI, d.MIMEType, d.Size = downloadInfo(download)
+		d.SuggestedFilename = suggestedFilename
+		if v.onDownload != nil {
+			policy = v.onDownload(d)
+		}
+		switch policy {
+		case DownloadToDirectory:
+			dir := v.downloadDir
+			if dir == "" {
+				dir = os.TempDir()
+			}
+			if path, err = createUniqueFile(dir, suggestedFilename); err == nil {
+				d.Path = path
+				setDownloadDestination(download, path, true)
+			}
+		case DownloadToMemory:
+			var f *os.File
+			if f, err = ioutil.TempFile("", "webloop-download-"); err == nil {
+				f.Close()
+				path = f.Name()
+				setDownloadDestination(download, path, true)
+			}
+		default:
+			err = ErrDownloadRejected
+		}
+		if err != nil {
+			cancelDownload(download)
+			return true
+		}
+		v.sendEvent(Event{Type: EventDownloadStarted, Download: d})
+		return true
+	})
+	download.Connect("received-data", func() {
+		v.sendEvent(Event{Type: EventDownloadProgress, Progress: downloadProgress(download), Download: d})
+	})
+	download.Connect("failed", func(_ *glib.Object, gerr uintptr) {
+		if err == nil {
+			err = ErrDownloadFailed
+			if msg := gerrorMessage(gerr); msg != "" {
+				err = fmt.Errorf("%w: %s", ErrDownloadFailed, msg)
+			}
+		}
+	})
+	download.Connect("finished", func() {
+		if policy == DownloadToMemory && path != "" {
+			if err == nil {
+				d.Data, err = ioutil.ReadFile(path)
+			}
+			os.Remove(path)
+		}
+		v.finishDownload(d, err)
+	})
+}
+
+// finishDownload is called on the GTK+ thread when the download d finishes
+// (if err is nil) or fails.
+func (v *View) finishDownload(d *Download, err error) {
+	d.Err = err
+	v.mu.Lock()
+	d.finished = true
+	v.stateChanged()
+	v.mu.Unlock()
+	close(d.done)
+
+	if err != nil {
+		v.sendEvent(Event{Type: EventDownloadFailed, Err: err, Download: d})
+	} else {
+		v.sendEvent(Event{Type: EventDownloadFinished, Download: d})
+	}
+}
+
+// WaitForDownload waits for the oldest download that the view started (and
+// that WaitForDownload hasn't already returned) to finish, and returns it. The
+// download may have been started before WaitForDownload was called. If the
+// download was rejected or failed, its Err is set, but WaitForDownload still
+// returns it with a nil error.
+func (v *View) WaitForDownload(timeout time.Duration) (*Download, error) {
+	var d *Download
+	err := v.waitFor(time.Now().Add(timeout), func() (bool, time.Duration) {
+		if len(v.downloads) == 0 || !v.downloads[0].finished {
+			return false, 0
+		}
+		d = v.downloads[0]
+		v.downloads = v.downloads[1:]
+		return true, 0
+	})
+	return d, err
+}
+
+// createUniqueFile creates an empty file in dir named name (with any directory
+// components removed), or, if that file already exists, "name (1)",
+// "name (2)", etc. (before the extension), and returns its path.
+func createUniqueFile(dir, name string) (string, error) {
+	name = filepath.Base(strings.Replace(name, "\\", "/", -1))
+	if name == "." || name == "/" || name == ".." {
+		name = "download"
+	}
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		path := filepath.Join(dir, name)
+		if i > 0 {
+			path = filepath.Join(dir, stem+" ("+strconv.Itoa(i)+")"+ext)
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return path, f.Close()
+	}
+}
@@ -0,0 +1,130 @@
+package webloop
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCreateUniqueFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webloop-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"report.csv", "report.csv"},
+		{"report.csv", "report (1).csv"},
+		{"report.csv", "report (2).csv"},
+		{"../../etc/passwd", "passwd"},
+		{`C:\Users\x\data.zip`, "data.zip"},
+		{"", "download"},
+		{"", "download (1)"},
+	}
+	for _, test := range tests {
+		path, err := createUniqueFile(dir, test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := filepath.Join(dir, test.want); path != want {
+			t.Errorf("%q: want %q, got %q", test.name, want, path)
+		}
+	}
+}
+
+func TestView_WaitForDownload(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<html><body><a id="export" href="/export.csv">Export</a></body></html>`))
+	})
+	mux.HandleFunc("/export.csv", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="export.csv"`)
+		w.Write([]byte("a,b\n1,2\n"))
+	})
+
+	c := &Context{OnDownload: func(*Download) DownloadPolicy { return DownloadToMemory }}
+	view := c.NewView()
+	defer view.Close()
+	view.Open(server.URL)
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := view.EvaluateJavaScript(`document.getElementById("export").click()`); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := view.WaitForDownload(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Err != nil {
+		t.Fatal(d.Err)
+	}
+	if want := server.URL + "/export.csv"; d.URI != want {
+		t.Errorf("want URI %q, got %q", want, d.URI)
+	}
+	if d.SuggestedFilename != "export.csv" {
+		t.Errorf("want suggested filename %q, got %q", "export.csv", d.SuggestedFilename)
+	}
+	if want := "a,b\n1,2\n"; string(d.Data) != want {
+		t.Errorf("want data %q, got %q", want, d.Data)
+	}
+
+	if _, err := view.WaitForDownload(100 * time.Millisecond); err != ErrWaitTimeout {
+		t.Errorf("want ErrWaitTimeout with no more downloads, got %v", err)
+	}
+}
+
+func TestView_WaitForDownload_failed(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<html><body><a id="export" href="/export.csv">Export</a></body></html>`))
+	})
+	mux.HandleFunc("/export.csv", func(w http.ResponseWriter, _ *http.Request) {
+		// The connection is closed before the promised 100 bytes are sent.
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="export.csv"`)
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("a,b\n"))
+		w.(http.Flusher).Flush()
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	})
+
+	c := &Context{OnDownload: func(*Download) DownloadPolicy { return DownloadToMemory }}
+	view := c.NewView()
+	defer view.Close()
+	view.Open(server.URL)
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := view.EvaluateJavaScript(`document.getElementById("export").click()`); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := view.WaitForDownload(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !errors.Is(d.Err, ErrDownloadFailed) {
+		t.Fatalf("want error wrapping ErrDownloadFailed, got %v", d.Err)
+	}
+	if d.Err == ErrDownloadFailed {
+		t.Error("want error with WebKit's description of the failure, got only ErrDownloadFailed")
+	}
+}
@@ -0,0 +1,145 @@
+package webloop
+
+// EventType is the type of an Event.
+type EventType string
+
+const (
+	// EventLoadStarted is sent when the view starts loading a page.
+	EventLoadStarted EventType = "load-started"
+
+	// EventLoadRedirected is sent when the request for the page is
+	// redirected. The Event's URI is the URI it was redirected to.
+	EventLoadRedirected EventType = "load-redirected"
+
+	// EventLoadCommitted is sent when the view starts receiving the page's
+	// content.
+	EventLoadCommitted EventType = "load-committed"
+
+	// EventLoadFinished is sent when the view finishes loading the page
+	// (whether or not the load failed).
+	EventLoadFinished EventType = "load-finished"
+
+	// EventLoadFailed is sent when the page fails to load (including when
+	// the load is stopped or superseded).
+	EventLoadFailed EventType = "load-failed"
+
+	// EventTitleChanged is sent when the page's title changes.
+	EventTitleChanged EventType = "title-changed"
+
+	// EventURIChanged is sent when the view's URI changes, including for
+	// in-page navigations (such as history.pushState and fragment changes)
+	// in single-page applications.
+	EventURIChanged EventType = "uri-changed"
+
+	// EventProgress is sent when the estimated progress of the load
+	// changes.
+	EventProgress EventType = "progress"
+
+	// EventDownloadStarted is sent when the view starts saving a file that
+	// a page downloads (after the Context's OnDownload func accepts it).
+	EventDownloadStarted EventType = "download-started"
+
+	// EventDownloadProgress is sent when the view receives more of a
+	// downloaded file.
+	EventDownloadProgress EventType = "download-progress"
+
+	// EventDownloadFinished is sent when a download finishes successfully.
+	EventDownloadFinished EventType = "download-finished"
+
+	// EventDownloadFailed is sent when a download is rejected or fails.
+	EventDownloadFailed EventType = "download-failed"
+)
+
+// Event describes something that happened in a View.
+type Event struct {
+	// Type is the type of the event.
+	Type EventType
+
+	// URI is the view's URI when the event occurred.
+	URI string
+
+	// Title is the page's title, for EventTitleChanged.
+	Title string
+
+	// Progress is the estimated progress of the load, from 0 to 1, for
+	// EventProgress, or of the download, for EventDownloadProgress.
+	Progress float64
+
+	// Err is the reason the load failed, for EventLoadFailed, or the
+	// download failed, for EventDownloadFailed.
+	Err error
+
+	// Download is the download, for the download events. Its Path, Data
+	// and Err must not be read until its Done channel is closed.
+	Download *Download
+}
+
+// eventBufferSize is the capacity of the channel returned by View.Events.
+const eventBufferSize = 100
+
+// Events returns a channel on which the view sends events, such as page loads
+// and URI changes. Events are only sent after the first call to Events. The
+// view doesn't wait for events to be received: if the channel's buffer is
+// full, events are dropped. The channel is closed when the view is closed.
+func (v *View) Events() <-chan Event {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.events == nil {
+		v.events = make(chan Event, eventBufferSize)
+		if v.eventsClosed {
+			close(v.events)
+		}
+	}
+	return v.events
+}
+
+// sendEvent sends e on the view's events channel, if Events has been called.
+// It is called on the GTK+ thread.
+func (v *View) sendEvent(e Event) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.events == nil || v.eventsClosed {
+		return
+	}
+	if e.URI == "" {
+		e.URI = v.WebView.URI()
+	}
+	select {
+	case v.events <- e:
+	default:
+	}
+}
+
+// closeEvents closes the view's events channel and stops sending events.
+func (v *View) closeEvents() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.eventsClosed = true
+	if v.events != nil {
+		close(v.events)
+	}
+}
+
+// connectEvents connects the signal handlers that send the view's events and
+// track its state for the WaitFor methods. It is called on the GTK+ thread.
+func (v *View) connectEvents() {
+	v.WebView.Connect("notify::title", func() {
+		v.sendEvent(Event{Type: EventTitleChanged, Title: v.WebView.Title()})
+	})
+	v.WebView.Connect("notify::uri", func() {
+		uri := v.WebView.URI()
+		v.navigated(uri)
+		v.sendEvent(Event{Type: EventURIChanged, URI: uri})
+	})
+	v.WebView.Connect("notify::is-loading", func() {
+		loading, _ := v.WebView.GetProperty("is-loading")
+		v.loadingChanged(loading == true)
+	})
+	v.WebView.Connect("notify::estimated-load-progress", func() {
+		e := Event{Type: EventProgress}
+		if p, err := v.WebView.GetProperty("estimated-load-progress"); err == nil {
+			e.Progress, _ = p.(float64)
+		}
+		v.sendEvent(e)
+	})
+}
@@ -0,0 +1,167 @@
+package webloop
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestView_Events(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<title>foo</title>`))
+	})
+
+	view := ctx.NewView()
+	defer view.Close()
+	events := view.Events()
+
+	view.Open(server.URL)
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := view.EvaluateJavaScript(`history.pushState(null, "", "/bar"); true`); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[EventType]bool{
+		EventLoadStarted:   false,
+		EventLoadCommitted: false,
+		EventLoadFinished:  false,
+		EventTitleChanged:  false,
+		EventURIChanged:    false,
+	}
+	timeout := time.After(5 * time.Second)
+	pushed := false
+	for !pushed {
+		select {
+		case e := <-events:
+			if _, ok := want[e.Type]; ok {
+				want[e.Type] = true
+			}
+			if e.Type == EventTitleChanged && e.Title != "foo" {
+				t.Errorf("want title %q, got %q", "foo", e.Title)
+			}
+			if e.Type == EventURIChanged && e.URI == server.URL+"/bar" {
+				pushed = true
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for events; got %v", want)
+		}
+	}
+	for typ, got := range want {
+		if !got {
+			t.Errorf("want %s event", typ)
+		}
+	}
+
+	view.Close()
+	for range events {
+	}
+}
+
+func TestView_BackForward(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {})
+
+	view := ctx.NewView()
+	defer view.Close()
+
+	if view.Back() {
+		t.Error("want Back to be false with no history")
+	}
+
+	url1, url2 := server.URL+"/1", server.URL+"/2"
+	for _, url := range []string{url1, url2} {
+		view.Open(url)
+		if err := view.Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !view.Back() {
+		t.Fatal("want Back to be true")
+	}
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := view.URI(); got != url1 {
+		t.Errorf("after Back: want URI %q, got %q", url1, got)
+	}
+
+	if !view.Forward() {
+		t.Fatal("want Forward to be true")
+	}
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if got := view.URI(); got != url2 {
+		t.Errorf("after Forward: want URI %q, got %q", url2, got)
+	}
+
+	view.Reload(true)
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestView_BackForward_sameDocument(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {})
+
+	view := ctx.NewView()
+	defer view.Close()
+	view.Open(server.URL + "/a")
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// WebKit loads no page when moving between these entries, so Wait must
+	// return without a load.
+	wait := func(name string) {
+		done := make(chan error, 1)
+		go func() { done <- view.Wait() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s: Wait did not return", name)
+		}
+	}
+	tests := []struct {
+		name string
+		js   string
+		uri  string
+	}{
+		{"pushState", `history.pushState(null, "", "/b")`, server.URL + "/b"},
+		{"fragment", `location.hash = "c"`, server.URL + "/b#c"},
+	}
+	prev := server.URL + "/a"
+	for _, test := range tests {
+		if _, err := view.EvaluateJavaScript(test.js); err != nil {
+			t.Fatal(err)
+		}
+
+		if !view.Back() {
+			t.Fatalf("%s: want Back to be true", test.name)
+		}
+		wait(test.name + ": Back")
+		if got := view.URI(); got != prev {
+			t.Errorf("%s: after Back: want URI %q, got %q", test.name, prev, got)
+		}
+
+		if !view.Forward() {
+			t.Fatalf("%s: want Forward to be true", test.name)
+		}
+		wait(test.name + ": Forward")
+		if got := view.URI(); got != test.uri {
+			t.Errorf("%s: after Forward: want URI %q, got %q", test.name, test.uri, got)
+		}
+		prev = test.uri
+	}
+}
@@ -3,18 +3,12 @@ package webloop_test
 import (
 	"fmt"
 	"os"
-	"runtime"
 
-	"github.com/gotk3/gotk3/gtk"
 	"github.com/sourcegraph/webloop"
 )
 
 func Example() {
-	gtk.Init(nil)
-	go func() {
-		runtime.LockOSThread()
-		gtk.Main()
-	}()
+	webloop.Start()
 
 	ctx := webloop.New()
 	view := ctx.NewView()
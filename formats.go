@@ -0,0 +1,237 @@
+package webloop
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Format is a format in which a rendered page can be output.
+type Format string
+
+const (
+	// FormatHTML is the page's serialized DOM (its outerHTML).
+	FormatHTML Format = "html"
+
+	// FormatText is the text of the page's body, as rendered (its
+	// innerText).
+	FormatText Format = "text"
+
+	// FormatReadable is the text of the page's main content, such as an
+	// article, without navigation, headers, footers and sidebars. The main
+	// content is found heuristically.
+	FormatReadable Format = "readable"
+
+	// FormatMarkdown is the page's main content (as for FormatReadable)
+	// converted to Markdown.
+	FormatMarkdown Format = "markdown"
+)
+
+// contentType returns the MIME type of content in the format.
+func (f Format) contentType() string {
+	switch f {
+	case FormatText, FormatReadable:
+		return "text/plain; charset=utf-8"
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	}
+	return "text/html; charset=utf-8"
+}
+
+// renderScript converts the page to a format (its argument). It is only used
+// for the formats other than FormatHTML.
+const renderScript = `(function(format) {
+  if (format === "text") return document.body ? document.body.innerText : "";
+
+  // mainContent returns the element with the page's main content: its only
+  // <article> or <main> element, or else the element with the most
+  // paragraph text that isn't link text.
+  function mainContent() {
+    var marked = document.querySelectorAll("article, main, [role=main]");
+    if (marked.length === 1 && marked[0].textContent.trim().length >= 140) return marked[0];
+    var scores = new Map(), best = null, bestScore = 0;
+    Array.prototype.forEach.call(document.querySelectorAll("p, pre, td, blockquote"), function(p) {
+      var text = p.textContent.trim();
+      if (text.length < 25) return;
+      var score = 1 + text.split(",").length + Math.min(Math.floor(text.length / 100), 3);
+      var parent = p.parentElement, grandparent = parent && parent.parentElement;
+      if (parent) scores.set(parent, (scores.get(parent) || 0) + score);
+      if (grandparent && grandparent !== document.documentElement) scores.set(grandparent, (scores.get(grandparent) || 0) + score / 2);
+    });
+    scores.forEach(function(score, e) {
+      var links = 0;
+      Array.prototype.forEach.call(e.querySelectorAll("a"), function(a) { links += a.textContent.length; });
+      score *= 1 - links / Math.max(e.textContent.length, 1);
+      if (score > bestScore) { best = e; bestScore = score; }
+    });
+    return best || document.body;
+  }
+
+  var md = format === "markdown";
+  var skip = /^(SCRIPT|STYLE|NOSCRIPT|TEMPLATE|IFRAME|SVG|CANVAS|NAV|ASIDE|HEADER|FOOTER|FORM|BUTTON|INPUT|SELECT|TEXTAREA)$/;
+  var block = /^(P|DIV|SECTION|ARTICLE|MAIN|FIGURE|FIGCAPTION|DL|DT|DD|ADDRESS|DETAILS|SUMMARY|LI)$/;
+
+  function children(e) {
+    var out = "";
+    for (var c = e.firstChild; c; c = c.nextSibling) out += convert(c);
+    return out;
+  }
+  function paragraph(s) { return "\n\n" + s.trim() + "\n\n"; }
+  function convert(n) {
+    if (n.nodeType === Node.TEXT_NODE) {
+      var text = n.textContent.replace(/\s+/g, " ");
+      if (!md) return text;
+      // Escape inline markup, and block markers (such as "#", "-" and
+      // "1.") in case the text starts a line.
+      return text.replace(/([\\` + "`" + `*_\[\]])/g, "\\$1").replace(/^(\s*)([#>+=-])/, "$1\\$2").replace(/^(\s*\d+)([.)])(?=\s|$)/, "$1\\$2");
+    }
+    if (n.nodeType !== Node.ELEMENT_NODE) return "";
+    var tag = n.tagName.toUpperCase(), style = window.getComputedStyle(n);
+    if (skip.test(tag) || style.display === "none" || style.visibility === "hidden") return "";
+    var inner;
+    switch (tag) {
+    case "BR":
+      return "\n";
+    case "HR":
+      return md ? "\n\n---\n\n" : "\n\n";
+    case "H1": case "H2": case "H3": case "H4": case "H5": case "H6":
+      inner = children(n).trim();
+      return inner ? paragraph((md ? "######".substring(0, +tag[1]) + " " : "") + inner) : "";
+    case "PRE":
+      inner = n.textContent.replace(/\n$/, "");
+      return "\n\n" + (md ? "` + "```" + `\n" + inner + "\n` + "```" + `" : inner) + "\n\n";
+    case "CODE":
+      return md ? "` + "`" + `" + n.textContent + "` + "`" + `" : n.textContent;
+    case "STRONG": case "B":
+      inner = children(n);
+      return md && inner.trim() ? "**" + inner.trim() + "**" : inner;
+    case "EM": case "I":
+      inner = children(n);
+      return md && inner.trim() ? "_" + inner.trim() + "_" : inner;
+    case "A":
+      inner = children(n);
+      return md && inner.trim() && n.href && n.href.indexOf("javascript:") !== 0 ? "[" + inner.trim() + "](" + n.href + ")" : inner;
+    case "IMG":
+      return md && n.src ? "![" + (n.alt || "") + "](" + n.src + ")" : n.alt || "";
+    case "UL": case "OL":
+      var items = [];
+      Array.prototype.forEach.call(n.children, function(li) {
+        if (li.tagName.toUpperCase() !== "LI") return;
+        var marker = tag === "OL" ? (items.length + 1) + ". " : "- ";
+        items.push(marker + children(li).trim().replace(/\n+/g, "\n").replace(/\n/g, "\n  "));
+      });
+      return "\n\n" + items.join("\n") + "\n\n";
+    case "BLOCKQUOTE":
+      inner = children(n).trim();
+      return paragraph(md ? inner.replace(/^/gm, "> ") : inner);
+    case "TABLE":
+      var rows = Array.prototype.map.call(n.rows, function(row) {
+        return Array.prototype.map.call(row.cells, function(cell) { return children(cell).trim().replace(/\s+/g, " "); });
+      });
+      if (!md) return paragraph(rows.map(function(cells) { return cells.join("\t"); }).join("\n"));
+      if (!rows.length) return "";
+      var lines = rows.map(function(cells) { return "| " + cells.map(function(c) { return c.replace(/\|/g, "\\|"); }).join(" | ") + " |"; });
+      lines.splice(1, 0, "|" + rows[0].map(function() { return " --- |"; }).join(""));
+      return paragraph(lines.join("\n"));
+    }
+    inner = children(n);
+    return block.test(tag) ? paragraph(inner) : inner;
+  }
+
+  return convert(mainContent()).replace(/[ \t]+$/gm, "").replace(/^ (?=\S)/gm, "").replace(/\n{3,}/g, "\n\n").trim() + "\n";
+})`
+
+// Render returns the page currently loaded in the view in the given format.
+func (v *View) Render(format Format) (string, error) {
+	script := "document.documentElement.outerHTML"
+	switch format {
+	case FormatHTML:
+	case FormatText, FormatReadable, FormatMarkdown:
+		script = renderScript + `("` + string(format) + `")`
+	default:
+		return "", fmt.Errorf("unknown format %q", format)
+	}
+	result, err := v.EvaluateJavaScript(script)
+	if err != nil {
+		return "", err
+	}
+	content, _ := result.(string)
+	return content, nil
+}
+
+// FormatParameter is the query parameter with which clients can choose the
+// format of the page served by a StaticRenderer whose ServeFormats is set,
+// such as "/article?_format=markdown". It is removed from the target URL.
+const FormatParameter = "_format"
+
+// requestFormat returns the format that r asks for, from its FormatParameter
+// query parameter or else its Accept header. It returns false if the query
+// parameter is not a known format.
+func requestFormat(r *http.Request) (Format, bool) {
+	if f := r.URL.Query().Get(FormatParameter); f != "" {
+		switch Format(f) {
+		case FormatHTML, FormatText, FormatReadable, FormatMarkdown:
+			return Format(f), true
+		}
+		return "", false
+	}
+	return negotiateFormat(r.Header.Get("Accept")), true
+}
+
+// ReadableProfile is the value of the "profile" parameter of the text/plain
+// media type (as in "Accept: text/plain; profile=readable") with which
+// clients can ask a StaticRenderer for FormatReadable instead of FormatText.
+const ReadableProfile = "readable"
+
+// negotiateFormat returns the format for the media type with the highest
+// quality in the Accept header value accept (text/html, text/plain, text/plain
+// with ReadableProfile or text/markdown), or FormatHTML if none of them are
+// acceptable.
+func negotiateFormat(accept string) Format {
+	formats := map[string]Format{
+		"text/html":     FormatHTML,
+		"text/plain":    FormatText,
+		"text/markdown": FormatMarkdown,
+	}
+	best, bestQ := FormatHTML, 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		f, ok := formats[mediaType]
+		if !ok {
+			continue
+		}
+		if f == FormatText && params["profile"] == ReadableProfile {
+			f = FormatReadable
+		}
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(s, 64); err != nil {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best
+}
+
+// removeQueryParam returns a copy of u without the query parameter named
+// name. The other query parameters are preserved in their original order.
+func removeQueryParam(u *url.URL, name string) *url.URL {
+	var params []string
+	for _, p := range strings.Split(u.RawQuery, "&") {
+		if p != "" && p != name && !strings.HasPrefix(p, name+"=") {
+			params = append(params, p)
+		}
+	}
+	u2 := *u
+	u2.RawQuery = strings.Join(params, "&")
+	return &u2
+}
@@ -0,0 +1,97 @@
+package webloop
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateFormat(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   Format
+	}{
+		{"", FormatHTML},
+		{"*/*", FormatHTML},
+		{"text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", FormatHTML},
+		{"text/plain", FormatText},
+		{"text/markdown, text/html;q=0.5", FormatMarkdown},
+		{"text/html;q=0.1, text/plain;q=0.2", FormatText},
+		{"text/plain;q=bad", FormatHTML},
+		{"text/plain; profile=readable", FormatReadable},
+		{"text/plain;profile=readable;q=0.5, text/plain;q=0.4", FormatReadable},
+		{"text/plain; profile=other", FormatText},
+	}
+	for _, test := range tests {
+		if got := negotiateFormat(test.accept); got != test.want {
+			t.Errorf("%q: want %q, got %q", test.accept, test.want, got)
+		}
+	}
+}
+
+func TestRemoveQueryParam(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"/a", "/a"},
+		{"/a?_format=text", "/a"},
+		{"/a?x=1&_format=text&y=2", "/a?x=1&y=2"},
+		{"/a?_formats=1&_format", "/a?_formats=1"},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := removeQueryParam(u, FormatParameter).String(); got != test.want {
+			t.Errorf("%s: want %q, got %q", test.url, test.want, got)
+		}
+	}
+}
+
+func TestView_Render(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<html><head><title>T</title></head><body>` +
+			`<nav><a href="/">Home</a></nav>` +
+			`<article><h1>Title</h1><p>Some <strong>bold</strong> and <a href="/x">linked</a> text, long enough to be content.</p><ul><li>one</li><li>two</li></ul>` +
+			`<p># not a heading<br>- not a list<br>1. not a list</p></article>` +
+			`<footer>Footer</footer></body></html>`))
+	})
+
+	view := ctx.NewView()
+	defer view.Close()
+	view.Open(server.URL)
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[Format]string{
+		FormatReadable: "Title\n\nSome bold and linked text, long enough to be content.\n\n- one\n- two\n\n# not a heading\n- not a list\n1. not a list\n",
+		FormatMarkdown: "# Title\n\nSome **bold** and [linked](" + server.URL + "/x) text, long enough to be content.\n\n- one\n- two\n\n\\# not a heading\n\\- not a list\n1\\. not a list\n",
+	}
+	for format, want := range tests {
+		got, err := view.Render(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("%s: want %q, got %q", format, want, got)
+		}
+	}
+
+	text, err := view.Render(FormatText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text, "Home") || !strings.Contains(text, "Footer") || strings.Contains(text, "<") {
+		t.Errorf("want text of whole body, got %q", text)
+	}
+
+	if _, err := view.Render("pdf"); err == nil {
+		t.Error("want error for unknown format")
+	}
+}
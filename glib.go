@@ -0,0 +1,45 @@
+package webloop
+
+// #cgo pkg-config: glib-2.0
+// #include <glib.h>
+import "C"
+
+import (
+	"runtime"
+
+	"github.com/gotk3/gotk3/glib"
+)
+
+// This file wraps the parts of the GLib main loop API that gotk3 doesn't.
+// Unless noted otherwise, these may be called from any thread.
+
+// mainLoopRunning reports whether another thread is running a main loop on
+// the default main context (and therefore owns it).
+func mainLoopRunning() bool {
+	// The context is acquired by the current OS thread, so the goroutine
+	// mustn't move to another thread before releasing it.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	ctx := C.g_main_context_default()
+	if C.g_main_context_acquire(ctx) == C.FALSE {
+		return true
+	}
+	C.g_main_context_release(ctx)
+	return false
+}
+
+// onMainLoopThread reports whether the calling goroutine is running on the
+// thread that runs the main loop on the default main context.
+func onMainLoopThread() bool {
+	return C.g_main_context_is_owner(C.g_main_context_default()) != C.FALSE
+}
+
+// removeSource removes the source with the given ID from the default main
+// context, if it hasn't already been removed (for example, because it ran).
+// It must be called on the main loop thread.
+func removeSource(id glib.SourceHandle) {
+	if source := C.g_main_context_find_source_by_id(nil, C.guint(id)); source != nil {
+		C.g_source_destroy(source)
+	}
+}
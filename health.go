@@ -0,0 +1,158 @@
+package webloop
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ViewHealth describes how much a View has been used and how many resources
+// its web process uses.
+type ViewHealth struct {
+	// Loads is the number of pages the view has started loading.
+	Loads int
+
+	// Age is how long ago the view was created.
+	Age time.Duration
+
+	// WebProcessPID is the process ID of the view's WebKit web process, or
+	// zero if it is not known (see View.Health).
+	WebProcessPID int
+
+	// WebProcessMemory is the resident memory, in bytes, of the view's web
+	// process, or zero if it is not known.
+	WebProcessMemory uint64
+}
+
+// Health returns the view's health. WebKit doesn't report which web process
+// a view uses, so WebProcessPID and WebProcessMemory are only known when the
+// view is the only open View in this program and the program has a single
+// WebKit web process (which is then the view's). They are found by looking in
+// /proc, so on systems without /proc, they are zero.
+func (v *View) Health() ViewHealth {
+	v.mu.Lock()
+	h := ViewHealth{Loads: v.loads, Age: time.Since(v.created)}
+	v.mu.Unlock()
+
+	h.WebProcessPID = v.webProcessPID()
+	if h.WebProcessPID != 0 {
+		h.WebProcessMemory, _ = processRSS(h.WebProcessPID)
+	}
+	return h
+}
+
+var (
+	// openViewsMu protects openViews.
+	openViewsMu sync.Mutex
+
+	// openViews is the number of Views that have been created and not
+	// closed.
+	openViews int
+)
+
+// addOpenViews adds delta to the number of open views.
+func addOpenViews(delta int) {
+	openViewsMu.Lock()
+	defer openViewsMu.Unlock()
+	openViews += delta
+}
+
+// webProcessPID returns the PID of v's web process, or 0 if it can't be
+// determined unambiguously (see Health).
+func (v *View) webProcessPID() int {
+	openViewsMu.Lock()
+	n := openViews
+	openViewsMu.Unlock()
+	if n != 1 || v.isClosed() || v.Crashed() {
+		return 0
+	}
+	pids, err := childWebProcesses()
+	if err != nil || len(pids) != 1 {
+		return 0
+	}
+	return pids[0]
+}
+
+// webProcessComm is the name of WebKit web processes in /proc/PID/stat, which
+// is truncated to 15 characters.
+const webProcessComm = "WebKitWebProces"
+
+// childWebProcesses returns the PIDs, in ascending order, of the WebKit web
+// processes whose parent is this process.
+func childWebProcesses() ([]int, error) {
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		return nil, err
+	}
+	var pids []int
+	ppid := os.Getpid()
+	for _, stat := range stats {
+		data, err := ioutil.ReadFile(stat)
+		if err != nil {
+			// The process exited.
+			continue
+		}
+		pid, comm, parent, ok := parseProcStat(data)
+		if ok && parent == ppid && comm == webProcessComm {
+			pids = append(pids, pid)
+		}
+	}
+	sort.Ints(pids)
+	return pids, nil
+}
+
+// parseProcStat parses the PID, command name and parent PID from the contents
+// of a /proc/PID/stat file, which look like "1234 (comm) S 1 ...".
+func parseProcStat(data []byte) (pid int, comm string, ppid int, ok bool) {
+	lparen, rparen := bytes.IndexByte(data, '('), bytes.LastIndexByte(data, ')')
+	if lparen == -1 || rparen < lparen {
+		return 0, "", 0, false
+	}
+	pid, err := strconv.Atoi(string(bytes.TrimSpace(data[:lparen])))
+	if err != nil {
+		return 0, "", 0, false
+	}
+	fields := bytes.Fields(data[rparen+1:])
+	if len(fields) < 2 {
+		return 0, "", 0, false
+	}
+	ppid, err = strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return 0, "", 0, false
+	}
+	return pid, string(data[lparen+1 : rparen]), ppid, true
+}
+
+// processRSS returns the resident set size, in bytes, of the process pid.
+func processRSS(pid int) (uint64, error) {
+	data, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return 0, err
+	}
+	return parseVmRSS(data), nil
+}
+
+// parseVmRSS returns the VmRSS value, in bytes, from the contents of a
+// /proc/PID/status file, or zero if it is not present.
+func parseVmRSS(status []byte) uint64 {
+	for _, line := range bytes.Split(status, []byte("\n")) {
+		if !bytes.HasPrefix(line, []byte("VmRSS:")) {
+			continue
+		}
+		fields := bytes.Fields(line[len("VmRSS:"):])
+		if len(fields) == 0 {
+			return 0
+		}
+		kb, err := strconv.ParseUint(string(fields[0]), 10, 64)
+		if err != nil {
+			return 0
+		}
+		return kb * 1024
+	}
+	return 0
+}
@@ -0,0 +1,46 @@
+package webloop
+
+import "testing"
+
+func TestParseProcStat(t *testing.T) {
+	pid, comm, ppid, ok := parseProcStat([]byte("4321 (WebKitWebProces) S 1234 4321 1234 0 -1 4194560 ...\n"))
+	if !ok || pid != 4321 || comm != webProcessComm || ppid != 1234 {
+		t.Errorf("got pid %d, comm %q, ppid %d, ok %v", pid, comm, ppid, ok)
+	}
+
+	// Command names may contain spaces and parentheses.
+	if _, comm, ppid, ok := parseProcStat([]byte("7 (a (b) c) R 6 7")); !ok || comm != "a (b) c" || ppid != 6 {
+		t.Errorf("got comm %q, ppid %d, ok %v", comm, ppid, ok)
+	}
+
+	if _, _, _, ok := parseProcStat([]byte("garbage")); ok {
+		t.Error("want !ok for invalid stat")
+	}
+}
+
+func TestParseVmRSS(t *testing.T) {
+	status := "Name:\tWebKitWebProces\nVmPeak:\t 2000 kB\nVmRSS:\t  123456 kB\nThreads:\t12\n"
+	if got, want := parseVmRSS([]byte(status)), uint64(123456*1024); want != got {
+		t.Errorf("want %d, got %d", want, got)
+	}
+	if got := parseVmRSS([]byte("Name:\tkthreadd\n")); got != 0 {
+		t.Errorf("want 0 for status without VmRSS, got %d", got)
+	}
+}
+
+func TestStaticRenderer_recycleReason(t *testing.T) {
+	h := &StaticRenderer{RecycleAfterLoads: 10, RecycleAboveMemory: 1 << 30}
+	tests := []struct {
+		health ViewHealth
+		want   RecycleReason
+	}{
+		{health: ViewHealth{Loads: 9, WebProcessMemory: 1 << 20}, want: ""},
+		{health: ViewHealth{Loads: 10}, want: RecycleLoads},
+		{health: ViewHealth{Loads: 1, WebProcessMemory: 2 << 30}, want: RecycleMemory},
+	}
+	for _, test := range tests {
+		if got := h.recycleReason(test.health); test.want != got {
+			t.Errorf("%+v: want %q, got %q", test.health, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package webloop
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Metadata is the SEO-relevant metadata of a rendered page.
+type Metadata struct {
+	// Title is the page's title.
+	Title string `json:"title"`
+
+	// Description is the content of the page's <meta name="description">.
+	Description string `json:"description,omitempty"`
+
+	// Canonical is the page's canonical URL, from its
+	// <link rel="canonical">.
+	Canonical string `json:"canonical,omitempty"`
+
+	// Robots are the page's robots directives (such as "noindex" and
+	// "googlebot: nofollow"), from its <meta name="robots"> elements and
+	// the X-Robots-Tag header of its response, in lowercase.
+	Robots []string `json:"robots,omitempty"`
+
+	// OpenGraph are the page's Open Graph properties (<meta
+	// property="og:...">), keyed by property name (such as "og:image"),
+	// with the values of repeated properties in document order.
+	OpenGraph map[string][]string `json:"openGraph,omitempty"`
+
+	// Twitter are the page's Twitter card properties (<meta
+	// name="twitter:...">), keyed by property name (such as
+	// "twitter:card").
+	Twitter map[string]string `json:"twitter,omitempty"`
+
+	// Alternates are the page's versions in other languages, from its
+	// <link rel="alternate" hreflang="..."> elements.
+	Alternates []Alternate `json:"alternates,omitempty"`
+
+	// JSONLD are the parsed contents of the page's
+	// <script type="application/ld+json"> elements. Elements that aren't
+	// valid JSON are omitted, and the errors parsing them are in
+	// JSONLDErrors.
+	JSONLD       []interface{} `json:"jsonLD,omitempty"`
+	JSONLDErrors []string      `json:"jsonLDErrors,omitempty"`
+
+	// Headings is the page's outline: its h1-h6 elements in document order.
+	Headings []Heading `json:"headings,omitempty"`
+}
+
+// Heading is a heading (h1-h6) element in a page.
+type Heading struct {
+	Level int    `json:"level"`
+	Text  string `json:"text"`
+}
+
+// extractMetadataScript returns a JSON object describing the page's metadata
+// (except its robots directives), with the JSON-LD blocks as unparsed strings.
+const extractMetadataScript = `(function() {
+  function content(selector) {
+    var e = document.querySelector(selector);
+    return e ? e.getAttribute("content") || "" : "";
+  }
+  function properties(selector, attr, multiple) {
+    var props = {};
+    Array.prototype.forEach.call(document.querySelectorAll(selector), function(meta) {
+      var name = meta.getAttribute(attr), value = meta.getAttribute("content") || "";
+      if (multiple) {
+        (props[name] = props[name] || []).push(value);
+      } else {
+        props[name] = value;
+      }
+    });
+    return props;
+  }
+  var canonical = document.querySelector("link[rel=canonical][href]");
+  return JSON.stringify({
+    title: document.title,
+    description: content('meta[name="description" i]'),
+    canonical: canonical ? canonical.href : "",
+    openGraph: properties('meta[property^="og:"][content]', "property", true),
+    twitter: properties('meta[name^="twitter:"][content]', "name", false),
+    alternates: Array.prototype.map.call(document.querySelectorAll("link[rel=alternate][hreflang][href]"), function(link) {
+      return {hreflang: link.getAttribute("hreflang"), href: link.href};
+    }),
+    jsonLD: Array.prototype.map.call(document.querySelectorAll('script[type="application/ld+json"]'), function(script) {
+      return script.textContent;
+    }),
+    headings: Array.prototype.map.call(document.querySelectorAll("h1, h2, h3, h4, h5, h6"), function(h) {
+      return {level: parseInt(h.tagName.substring(1), 10), text: h.textContent.replace(/\s+/g, " ").trim()};
+    })
+  });
+})()`
+
+// ExtractMetadata returns the metadata of the page currently loaded in the
+// view, based on its rendered DOM.
+func (v *View) ExtractMetadata() (*Metadata, error) {
+	result, err := v.EvaluateJavaScript(extractMetadataScript)
+	if err != nil {
+		return nil, err
+	}
+	md, err := parseMetadata([]byte(result.(string)))
+	if err != nil {
+		return nil, err
+	}
+
+	// The robots directives are the same ones that RobotsRules use,
+	// including those in the X-Robots-Tag header.
+	robots, err := v.robots()
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range robots.directives {
+		md.Robots = append(md.Robots, strings.ToLower(d))
+	}
+	return md, nil
+}
+
+// parseMetadata parses the JSON returned by extractMetadataScript.
+func parseMetadata(data []byte) (*Metadata, error) {
+	var md struct {
+		Metadata
+		JSONLD []string `json:"jsonLD"`
+	}
+	if err := json.Unmarshal(data, &md); err != nil {
+		return nil, err
+	}
+	for _, block := range md.JSONLD {
+		var v interface{}
+		if err := json.Unmarshal([]byte(block), &v); err != nil {
+			md.Metadata.JSONLDErrors = append(md.Metadata.JSONLDErrors, err.Error())
+			continue
+		}
+		md.Metadata.JSONLD = append(md.Metadata.JSONLD, v)
+	}
+	return &md.Metadata, nil
+}
@@ -0,0 +1,73 @@
+package webloop
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseMetadata(t *testing.T) {
+	md, err := parseMetadata([]byte(`{"title": "t", "openGraph": {"og:image": ["a.png", "b.png"]}, "jsonLD": ["{\"@type\": \"Product\"}", "{bad"], "headings": [{"level": 1, "text": "h"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Metadata{
+		Title:     "t",
+		OpenGraph: map[string][]string{"og:image": {"a.png", "b.png"}},
+		JSONLD:    []interface{}{map[string]interface{}{"@type": "Product"}},
+		Headings:  []Heading{{Level: 1, Text: "h"}},
+	}
+	if len(md.JSONLDErrors) != 1 {
+		t.Errorf("want 1 JSON-LD error, got %v", md.JSONLDErrors)
+	}
+	md.JSONLDErrors = nil
+	if !reflect.DeepEqual(md, want) {
+		t.Errorf("want %+v, got %+v", want, md)
+	}
+}
+
+func TestView_ExtractMetadata(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("X-Robots-Tag", "noarchive")
+		w.Write([]byte(`<html><head>
+<title>Widget</title>
+<meta name="description" content="A widget.">
+<meta name="robots" content="NoIndex, follow">
+<meta property="og:title" content="OG Widget">
+<meta property="og:image" content="/front.png">
+<meta property="og:image" content="/back.png">
+<meta name="twitter:card" content="summary">
+<link rel="canonical" href="/widget">
+<link rel="alternate" hreflang="de" href="/de/widget">
+<script type="application/ld+json">{"@type": "Product", "name": "Widget"}</script>
+</head><body><h1>Widget</h1><h2> Price </h2></body></html>`))
+	})
+
+	view := ctx.NewView()
+	defer view.Close()
+	view.Open(server.URL)
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	md, err := view.ExtractMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Metadata{
+		Title:       "Widget",
+		Description: "A widget.",
+		Canonical:   server.URL + "/widget",
+		Robots:      []string{"noarchive", "noindex", "follow"},
+		OpenGraph:   map[string][]string{"og:title": {"OG Widget"}, "og:image": {"/front.png", "/back.png"}},
+		Twitter:     map[string]string{"twitter:card": "summary"},
+		Alternates:  []Alternate{{HrefLang: "de", Href: server.URL + "/de/widget"}},
+		JSONLD:      []interface{}{map[string]interface{}{"@type": "Product", "name": "Widget"}},
+		Headings:    []Heading{{Level: 1, Text: "Widget"}, {Level: 2, Text: "Price"}},
+	}
+	if !reflect.DeepEqual(md, want) {
+		t.Errorf("want %+v, got %+v", want, md)
+	}
+}
@@ -0,0 +1,91 @@
+package webloop
+
+import "time"
+
+// Metrics receives measurements of rendering and of the views used to render.
+// Implementations must be safe for concurrent use. See the
+// github.com/sourcegraph/webloop/prometheus package for an implementation that
+// exports Prometheus metrics.
+type Metrics interface {
+	// ObservePhase records how long a phase of rendering a page took.
+	ObservePhase(phase Phase, d time.Duration)
+
+	// CountOutcome records the outcome of rendering a page. A page that
+	// fails to load is counted as OutcomeLoadFailed and then, because it is
+	// still rendered, with the outcome of the rest of its render.
+	CountOutcome(outcome Outcome)
+
+	// AddViewsInUse adds delta to the number of views that are rendering
+	// pages.
+	AddViewsInUse(delta int)
+
+	// AddRendersWaiting adds delta to the number of renders that are waiting
+	// for a view to become available.
+	AddRendersWaiting(delta int)
+
+	// CountCacheLookup records a lookup in the rendered page cache and
+	// whether it was a hit.
+	CountCacheLookup(hit bool)
+
+	// CountWebProcessTermination records that a view's WebKit web process
+	// terminated unexpectedly (usually because it crashed or ran out of
+	// memory), making the view unusable.
+	CountWebProcessTermination()
+
+	// CountViewRecycle records that a StaticRenderer replaced its view with a
+	// new one for the given reason (see RecycleReason).
+	CountViewRecycle(reason RecycleReason)
+}
+
+// Phase is a phase of rendering a page.
+type Phase string
+
+const (
+	// PhaseLoad is loading the page, up to the WebKit load-finished event.
+	PhaseLoad Phase = "load"
+
+	// PhaseWait is waiting for the loaded page to become ready.
+	PhaseWait Phase = "wait"
+
+	// PhaseSerialize is serializing the page's DOM to HTML.
+	PhaseSerialize Phase = "serialize"
+)
+
+// Outcome is the result of rendering a page.
+type Outcome string
+
+const (
+	// OutcomeOK means the page became ready and was rendered.
+	OutcomeOK Outcome = "ok"
+
+	// OutcomeTimeoutUnfinished means the page did not become ready within
+	// the wait timeout and was rendered unfinished.
+	OutcomeTimeoutUnfinished Outcome = "timeout_unfinished"
+
+	// OutcomeTimeout502 means the page did not become ready within the wait
+	// timeout and an HTTP 502 error was returned.
+	OutcomeTimeout502 Outcome = "timeout_502"
+
+	// OutcomeJavaScriptError means evaluating JavaScript in the page failed.
+	OutcomeJavaScriptError Outcome = "javascript_error"
+
+	// OutcomeLoadFailed means the page failed to load (for example,
+	// because the origin server refused the connection). It is counted in
+	// addition to the render's final outcome.
+	OutcomeLoadFailed Outcome = "load_failed"
+
+	// OutcomeWebProcessCrashed means the view's web process crashed while
+	// rendering the page, both times it was tried.
+	OutcomeWebProcessCrashed Outcome = "web_process_crashed"
+)
+
+// nopMetrics is used when no Metrics are configured.
+type nopMetrics struct{}
+
+func (nopMetrics) ObservePhase(Phase, time.Duration) {}
+func (nopMetrics) CountOutcome(Outcome)              {}
+func (nopMetrics) AddViewsInUse(int)                 {}
+func (nopMetrics) AddRendersWaiting(int)             {}
+func (nopMetrics) CountCacheLookup(bool)             {}
+func (nopMetrics) CountWebProcessTermination()       {}
+func (nopMetrics) CountViewRecycle(RecycleReason)    {}
@@ -0,0 +1,109 @@
+// Package prometheus exports WebLoop rendering metrics to Prometheus.
+package prometheus
+
+import (
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/sourcegraph/webloop"
+)
+
+// Metrics implements webloop.Metrics by updating Prometheus collectors.
+type Metrics struct {
+	phaseDuration  *prom.HistogramVec
+	outcomes       *prom.CounterVec
+	viewsInUse     prom.Gauge
+	rendersWaiting prom.Gauge
+	cacheLookups   *prom.CounterVec
+	terminations   prom.Counter
+	recycles       *prom.CounterVec
+}
+
+var _ webloop.Metrics = (*Metrics)(nil)
+
+// New creates Metrics and registers their collectors with reg. If reg is nil,
+// prometheus.DefaultRegisterer is used.
+func New(reg prom.Registerer) *Metrics {
+	if reg == nil {
+		reg = prom.DefaultRegisterer
+	}
+	m := &Metrics{
+		phaseDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: "webloop",
+			Name:      "render_phase_duration_seconds",
+			Help:      "Time spent in each phase of rendering a page (load, wait, serialize).",
+			Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+		}, []string{"phase"}),
+		outcomes: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: "webloop",
+			Name:      "renders_total",
+			Help:      "Rendered pages, by outcome.",
+		}, []string{"outcome"}),
+		viewsInUse: prom.NewGauge(prom.GaugeOpts{
+			Namespace: "webloop",
+			Name:      "views_in_use",
+			Help:      "Views that are currently rendering pages.",
+		}),
+		rendersWaiting: prom.NewGauge(prom.GaugeOpts{
+			Namespace: "webloop",
+			Name:      "renders_waiting",
+			Help:      "Renders waiting for a view to become available.",
+		}),
+		cacheLookups: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: "webloop",
+			Name:      "cache_lookups_total",
+			Help:      "Lookups in the rendered page cache, by result (hit or miss).",
+		}, []string{"result"}),
+		terminations: prom.NewCounter(prom.CounterOpts{
+			Namespace: "webloop",
+			Name:      "web_process_terminations_total",
+			Help:      "Times a view's WebKit web process terminated unexpectedly (crashed).",
+		}),
+		recycles: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: "webloop",
+			Name:      "view_recycles_total",
+			Help:      "Times a view was replaced with a new one, by reason (loads, age or memory).",
+		}, []string{"reason"}),
+	}
+	reg.MustRegister(m.phaseDuration, m.outcomes, m.viewsInUse, m.rendersWaiting, m.cacheLookups, m.terminations, m.recycles)
+	return m
+}
+
+// ObservePhase implements webloop.Metrics.
+func (m *Metrics) ObservePhase(phase webloop.Phase, d time.Duration) {
+	m.phaseDuration.WithLabelValues(string(phase)).Observe(d.Seconds())
+}
+
+// CountOutcome implements webloop.Metrics.
+func (m *Metrics) CountOutcome(outcome webloop.Outcome) {
+	m.outcomes.WithLabelValues(string(outcome)).Inc()
+}
+
+// AddViewsInUse implements webloop.Metrics.
+func (m *Metrics) AddViewsInUse(delta int) {
+	m.viewsInUse.Add(float64(delta))
+}
+
+// AddRendersWaiting implements webloop.Metrics.
+func (m *Metrics) AddRendersWaiting(delta int) {
+	m.rendersWaiting.Add(float64(delta))
+}
+
+// CountCacheLookup implements webloop.Metrics.
+func (m *Metrics) CountCacheLookup(hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	m.cacheLookups.WithLabelValues(result).Inc()
+}
+
+// CountWebProcessTermination implements webloop.Metrics.
+func (m *Metrics) CountWebProcessTermination() {
+	m.terminations.Inc()
+}
+
+// CountViewRecycle implements webloop.Metrics.
+func (m *Metrics) CountViewRecycle(reason webloop.RecycleReason) {
+	m.recycles.WithLabelValues(string(reason)).Inc()
+}
@@ -0,0 +1,81 @@
+package webloop
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+// ProxySettings configures the proxies that views use to load pages and their
+// resources.
+type ProxySettings struct {
+	// HTTP and HTTPS are the URLs of the proxies to use for http and https
+	// requests, such as "http://proxy.internal:3128".
+	HTTP, HTTPS string
+
+	// SOCKS is the URL of the proxy to use for requests that HTTP and HTTPS
+	// don't apply to, such as "socks5://proxy.internal:1080".
+	SOCKS string
+
+	// Bypass lists the hosts to connect to directly. Each entry is a host
+	// name (".example.com" and "*.example.com" also match subdomains), an
+	// IP address or a CIDR range; "*" matches all hosts.
+	Bypass []string
+}
+
+// direct reports whether p doesn't proxy any requests.
+func (p *ProxySettings) direct() bool {
+	if p.HTTP == "" && p.HTTPS == "" && p.SOCKS == "" {
+		return true
+	}
+	for _, host := range p.Bypass {
+		if host == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// ProxyFromEnvironment returns the proxy settings from the HTTP_PROXY,
+// HTTPS_PROXY, ALL_PROXY (for SOCKS) and NO_PROXY environment variables (or
+// their lowercase versions). If none of them are set, it returns nil.
+func ProxyFromEnvironment() *ProxySettings {
+	return proxyFromEnv(os.Getenv)
+}
+
+func proxyFromEnv(getenv func(string) string) *ProxySettings {
+	get := func(name string) string {
+		if v := getenv(name); v != "" {
+			return v
+		}
+		return getenv(strings.ToLower(name))
+	}
+	p := &ProxySettings{
+		HTTP:  get("HTTP_PROXY"),
+		HTTPS: get("HTTPS_PROXY"),
+		SOCKS: get("ALL_PROXY"),
+	}
+	if p.HTTP == "" && p.HTTPS == "" && p.SOCKS == "" {
+		return nil
+	}
+	for _, host := range strings.Split(get("NO_PROXY"), ",") {
+		if host = strings.TrimSpace(host); host != "" {
+			p.Bypass = append(p.Bypass, host)
+		}
+	}
+	return p
+}
+
+// proxyOnce applies the proxy settings of the first View's Context to WebKit's
+// network session, which all views share. Changing the settings for later
+// views would change them for the loads of all earlier views too.
+var proxyOnce sync.Once
+
+// proxySettings returns the context's proxy settings, or nil to use WebKit's
+// default (system) proxy settings.
+func (c *Context) proxySettings() *ProxySettings {
+	if c.Proxy != nil {
+		return c.Proxy
+	}
+	return ProxyFromEnvironment()
+}
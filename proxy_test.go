@@ -0,0 +1,47 @@
+package webloop
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProxyFromEnv(t *testing.T) {
+	tests := []struct {
+		env  map[string]string
+		want *ProxySettings
+	}{
+		{env: map[string]string{}, want: nil},
+		{env: map[string]string{"NO_PROXY": "localhost"}, want: nil},
+		{
+			env:  map[string]string{"HTTP_PROXY": "http://p:3128", "https_proxy": "http://q:3128", "NO_PROXY": "localhost, .internal,"},
+			want: &ProxySettings{HTTP: "http://p:3128", HTTPS: "http://q:3128", Bypass: []string{"localhost", ".internal"}},
+		},
+		{
+			env:  map[string]string{"ALL_PROXY": "socks5://s:1080", "all_proxy": "socks5://ignored:1080"},
+			want: &ProxySettings{SOCKS: "socks5://s:1080"},
+		},
+	}
+	for _, test := range tests {
+		got := proxyFromEnv(func(name string) string { return test.env[name] })
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: want %+v, got %+v", test.env, test.want, got)
+		}
+	}
+}
+
+func TestProxySettings_direct(t *testing.T) {
+	tests := []struct {
+		p    ProxySettings
+		want bool
+	}{
+		{ProxySettings{}, true},
+		{ProxySettings{HTTP: "http://p:3128"}, false},
+		{ProxySettings{HTTP: "http://p:3128", Bypass: []string{"localhost"}}, false},
+		{ProxySettings{HTTP: "http://p:3128", Bypass: []string{"*"}}, true},
+	}
+	for _, test := range tests {
+		if got := test.p.direct(); got != test.want {
+			t.Errorf("%+v: want direct == %v, got %v", test.p, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package webloop
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// robotsRules are the Allow and Disallow rules from a robots.txt file that
+// apply to a particular user agent.
+type robotsRules []robotsRule
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// parseRobots parses the robots.txt file read from r and returns the rules
+// for the user agent named agent (such as "WebLoop"). The rules in the first
+// group whose User-agent line is contained in agent (ignoring case) are used,
+// or else the rules in the "*" group.
+func parseRobots(r io.Reader, agent string) (robotsRules, error) {
+	agent = strings.ToLower(agent)
+	var (
+		groups      = map[string]robotsRules{}
+		order       []string // group names in the order they appear
+		current     []string // user agents of the current group
+		inGroupBody bool
+	)
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line := s.Text()
+		if i := strings.Index(line, "#"); i != -1 {
+			line = line[:i]
+		}
+		i := strings.Index(line, ":")
+		if i == -1 {
+			continue
+		}
+		key, value := strings.ToLower(strings.TrimSpace(line[:i])), strings.TrimSpace(line[i+1:])
+		switch key {
+		case "user-agent":
+			if inGroupBody {
+				current, inGroupBody = nil, false
+			}
+			ua := strings.ToLower(value)
+			current = append(current, ua)
+			if _, present := groups[ua]; !present {
+				groups[ua] = nil
+				order = append(order, ua)
+			}
+		case "allow", "disallow":
+			inGroupBody = true
+			if value == "" {
+				// An empty Disallow allows everything.
+				continue
+			}
+			for _, ua := range current {
+				groups[ua] = append(groups[ua], robotsRule{allow: key == "allow", pattern: value})
+			}
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, ua := range order {
+		if ua != "*" && strings.Contains(agent, ua) {
+			return groups[ua], nil
+		}
+	}
+	return groups["*"], nil
+}
+
+// allowed reports whether the rules allow fetching the URL with the given path
+// (and query). The longest matching rule wins; if an Allow and a Disallow rule
+// match equally, Allow wins.
+func (rules robotsRules) allowed(path string) bool {
+	allow, longest := true, -1
+	for _, rule := range rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		if n := len(rule.pattern); n > longest || (n == longest && rule.allow) {
+			allow, longest = rule.allow, n
+		}
+	}
+	return allow
+}
+
+// robotsMatch reports whether path matches a robots.txt path pattern, which
+// may contain "*" wildcards and end with "$" to anchor it.
+func robotsMatch(pattern, path string) bool {
+	if !strings.ContainsAny(pattern, "*$") {
+		return strings.HasPrefix(path, pattern)
+	}
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr).MatchString(path)
+}
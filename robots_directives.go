@@ -0,0 +1,219 @@
+package webloop
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// RobotsRule says how the robots directives of the rendered pages under a path
+// prefix affect the responses that a StaticRenderer sends for them. A page's
+// robots directives come from its rendered <meta name="robots"> elements (so
+// they may be set by JavaScript) and the target's X-Robots-Tag response
+// header.
+type RobotsRule struct {
+	// Prefix is the path prefix of the pages that the rule applies to.
+	Prefix string
+
+	// Header is whether to send the page's robots directives in
+	// X-Robots-Tag response headers: one for the directives for all
+	// crawlers, and one for each user agent that directives are scoped to.
+	Header bool
+
+	// NoindexStatus, if non-zero, is the HTTP status code (such as 404 or
+	// 410) to respond with for pages whose directives include noindex or
+	// none, unless another status applies (see StaticRenderer.StatusChecks).
+	NoindexStatus int
+
+	// NoCacheNoindex is whether pages whose directives include noindex or
+	// none are not cached.
+	NoCacheNoindex bool
+
+	// StatusMeta is whether pages can set the HTTP status code of the
+	// response with a <meta name="prerender-status-code" content="404">
+	// element (as with the Prerender service). It takes precedence over
+	// StatusChecks and NoindexStatus, but not over StatusCodeVariable.
+	StatusMeta bool
+}
+
+// matchRobotsRule returns the rule in rules with the longest prefix of path,
+// or nil if none apply.
+func matchRobotsRule(rules []RobotsRule, path string) *RobotsRule {
+	var rule *RobotsRule
+	for i, r := range rules {
+		if strings.HasPrefix(path, r.Prefix) && (rule == nil || len(r.Prefix) > len(rule.Prefix)) {
+			rule = &rules[i]
+		}
+	}
+	return rule
+}
+
+// pageRobots is the robots information of a rendered page.
+type pageRobots struct {
+	// directives are the page's robots directives, such as "noindex" or
+	// "googlebot: nofollow".
+	directives []string
+
+	// status is the HTTP status code in the page's
+	// <meta name="prerender-status-code">, or 0 if there is none.
+	status int
+}
+
+// noindex reports whether the directives that apply to all crawlers include
+// noindex or none.
+func (r *pageRobots) noindex() bool {
+	for _, d := range r.directives {
+		if d = strings.ToLower(d); d == "noindex" || d == "none" {
+			return true
+		}
+	}
+	return false
+}
+
+// extractRobotsScript returns a JSON object with the directives in the page's
+// <meta name="robots"> elements and the value of its
+// <meta name="prerender-status-code">.
+const extractRobotsScript = `(function() {
+  var robots = [];
+  Array.prototype.forEach.call(document.querySelectorAll('meta[name="robots" i][content]'), function(meta) {
+    robots.push(meta.getAttribute("content"));
+  });
+  var status = document.querySelector('meta[name="prerender-status-code" i][content]');
+  return JSON.stringify({robots: robots, status: status ? status.getAttribute("content") : ""});
+})()`
+
+// robots returns the robots information of the page currently loaded in the
+// view.
+func (v *View) robots() (*pageRobots, error) {
+	result, err := v.EvaluateJavaScript(extractRobotsScript)
+	if err != nil {
+		return nil, err
+	}
+	var page struct {
+		Robots []string `json:"robots"`
+		Status string   `json:"status"`
+	}
+	if err := json.Unmarshal([]byte(result.(string)), &page); err != nil {
+		return nil, err
+	}
+
+	var header []string
+	if err := v.do(func() { header = mainResourceHeader(v.WebView, "X-Robots-Tag") }); err != nil {
+		return nil, err
+	}
+
+	r := &pageRobots{directives: splitDirectives(append(header, page.Robots...))}
+	if status, err := strconv.Atoi(strings.TrimSpace(page.Status)); err == nil && status >= 100 && status <= 599 {
+		r.status = status
+	}
+	return r, nil
+}
+
+// valuedDirectives are the robots directives that take a value after a colon,
+// in lowercase. Any other name before a colon is a crawler's user agent.
+var valuedDirectives = map[string]bool{
+	"unavailable_after": true,
+	"max-snippet":       true,
+	"max-image-preview": true,
+	"max-video-preview": true,
+}
+
+// splitDirectives splits comma-separated robots directives, removing
+// duplicates and empty directives. A user agent prefix (as in "googlebot:
+// noindex, nofollow") applies to the directives after it in the same value,
+// and each of them is returned with the prefix (as "googlebot: noindex" and
+// "googlebot: nofollow").
+func splitDirectives(values []string) []string {
+	var directives []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		ua := ""
+		parts := strings.Split(v, ",")
+		for i := 0; i < len(parts); i++ {
+			d := strings.TrimSpace(parts[i])
+			if name, rest := userAgentPrefix(d); name != "" {
+				ua, d = name, rest
+			}
+			// Dates in unavailable_after may contain commas (as in
+			// "Friday, 25-Jun-10 15:00:00 PST"), after which they
+			// continue with a day number.
+			if strings.HasPrefix(strings.ToLower(d), "unavailable_after") {
+				for i+1 < len(parts) && startsWithDigit(strings.TrimSpace(parts[i+1])) {
+					i++
+					d += "," + parts[i]
+				}
+			}
+			if d == "" {
+				continue
+			}
+			if ua != "" {
+				d = ua + ": " + d
+			}
+			if key := strings.ToLower(d); !seen[key] {
+				seen[key] = true
+				directives = append(directives, d)
+			}
+		}
+	}
+	return directives
+}
+
+// userAgentPrefix splits the user agent prefix (as in "googlebot: noindex")
+// from the robots directive d. If d has no user agent prefix, ua is empty.
+func userAgentPrefix(d string) (ua, directive string) {
+	if j := strings.Index(d, ":"); j != -1 {
+		if name := strings.TrimSpace(d[:j]); !strings.Contains(name, " ") && !valuedDirectives[strings.ToLower(name)] {
+			return name, strings.TrimSpace(d[j+1:])
+		}
+	}
+	return "", d
+}
+
+// robotsHeaderValues returns the X-Robots-Tag header values for directives (as
+// returned by splitDirectives): one for the directives without a user agent
+// prefix, followed by one for each user agent. Crawlers apply a user agent
+// prefix to all the directives after it in a value, so directives for
+// different user agents can't share a value.
+func robotsHeaderValues(directives []string) []string {
+	var (
+		uas    []string
+		scoped = map[string][]string{}
+	)
+	for _, d := range directives {
+		ua, directive := userAgentPrefix(d)
+		key := strings.ToLower(ua)
+		if _, ok := scoped[key]; !ok && ua != "" {
+			uas = append(uas, ua)
+		}
+		scoped[key] = append(scoped[key], directive)
+	}
+	var values []string
+	if ds := scoped[""]; len(ds) > 0 {
+		values = append(values, strings.Join(ds, ", "))
+	}
+	for _, ua := range uas {
+		values = append(values, ua+": "+strings.Join(scoped[strings.ToLower(ua)], ", "))
+	}
+	return values
+}
+
+func startsWithDigit(s string) bool {
+	return s != "" && s[0] >= '0' && s[0] <= '9'
+}
+
+// applyRobots applies rule to the rendered page p, whose robots information is
+// r, setting the statuses that the rule gives p in s. It returns whether p may
+// be cached.
+func (p *renderedPage) applyRobots(rule *RobotsRule, r *pageRobots, s *pageStatuses) (cacheable bool) {
+	if rule.Header && len(r.directives) > 0 {
+		p.robots = robotsHeaderValues(r.directives)
+	}
+	if rule.StatusMeta {
+		s.meta = r.status
+	}
+	noindex := r.noindex()
+	if noindex {
+		s.noindex = rule.NoindexStatus
+	}
+	return !(noindex && rule.NoCacheNoindex)
+}
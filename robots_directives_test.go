@@ -0,0 +1,122 @@
+package webloop
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchRobotsRule(t *testing.T) {
+	rules := []RobotsRule{{Prefix: "/"}, {Prefix: "/search", Header: true}, {Prefix: "/s"}}
+	tests := []struct {
+		path string
+		want *RobotsRule
+	}{
+		{"/", &rules[0]},
+		{"/about", &rules[0]},
+		{"/search?q=a", &rules[1]},
+		{"/static", &rules[2]},
+	}
+	for _, test := range tests {
+		if got := matchRobotsRule(rules, test.path); got != test.want {
+			t.Errorf("%s: want rule %+v, got %+v", test.path, test.want, got)
+		}
+	}
+	if got := matchRobotsRule(rules[1:], "/about"); got != nil {
+		t.Errorf("want no rule, got %+v", got)
+	}
+}
+
+func TestSplitDirectives(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   []string
+	}{
+		{
+			values: []string{"noindex, nofollow", "", " NOINDEX,googlebot: noarchive ,"},
+			want:   []string{"noindex", "nofollow", "googlebot: noarchive"},
+		},
+		{
+			values: []string{"googlebot: noindex, nofollow", "nosnippet", "otherbot: noarchive, googlebot: none"},
+			want:   []string{"googlebot: noindex", "googlebot: nofollow", "nosnippet", "otherbot: noarchive", "googlebot: none"},
+		},
+		{
+			values: []string{"max-snippet: 20, max-image-preview:large"},
+			want:   []string{"max-snippet: 20", "max-image-preview:large"},
+		},
+		{
+			values: []string{"unavailable_after: Friday, 25-Jun-10 15:00:00 PST, noarchive", "googlebot: unavailable_after: 25 Jun 2010 15:00:00 PST, nofollow"},
+			want:   []string{"unavailable_after: Friday, 25-Jun-10 15:00:00 PST", "noarchive", "googlebot: unavailable_after: 25 Jun 2010 15:00:00 PST", "googlebot: nofollow"},
+		},
+	}
+	for _, test := range tests {
+		if got := splitDirectives(test.values); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: want %q, got %q", test.values, test.want, got)
+		}
+	}
+}
+
+func TestRenderedPage_applyRobots(t *testing.T) {
+	tests := []struct {
+		rule          RobotsRule
+		robots        pageRobots
+		want          renderedPage
+		wantCacheable bool
+	}{
+		{
+			rule:          RobotsRule{Header: true, NoindexStatus: 404, NoCacheNoindex: true},
+			robots:        pageRobots{},
+			want:          renderedPage{},
+			wantCacheable: true,
+		},
+		{
+			rule:          RobotsRule{Header: true},
+			robots:        pageRobots{directives: []string{"noindex", "nofollow"}},
+			want:          renderedPage{robots: []string{"noindex, nofollow"}},
+			wantCacheable: true,
+		},
+		{
+			// Directives for different user agents are sent in
+			// separate values, so that the user agent prefixes
+			// don't apply to the others' directives.
+			rule:          RobotsRule{Header: true},
+			robots:        pageRobots{directives: []string{"googlebot: noindex", "nofollow", "bingbot: noarchive", "Googlebot: unavailable_after: 25 Jun 2010", "noimageindex"}},
+			want:          renderedPage{robots: []string{"nofollow, noimageindex", "googlebot: noindex, unavailable_after: 25 Jun 2010", "bingbot: noarchive"}},
+			wantCacheable: true,
+		},
+		{
+			rule:          RobotsRule{NoindexStatus: 404, NoCacheNoindex: true},
+			robots:        pageRobots{directives: []string{"None"}},
+			want:          renderedPage{status: 404},
+			wantCacheable: false,
+		},
+		{
+			// Directives for specific crawlers don't make the page
+			// noindex.
+			rule:          RobotsRule{NoindexStatus: 404, NoCacheNoindex: true},
+			robots:        pageRobots{directives: []string{"googlebot: noindex"}},
+			want:          renderedPage{},
+			wantCacheable: true,
+		},
+		{
+			rule:          RobotsRule{StatusMeta: true},
+			robots:        pageRobots{status: 410},
+			want:          renderedPage{status: 410},
+			wantCacheable: true,
+		},
+		{
+			rule:          RobotsRule{},
+			robots:        pageRobots{directives: []string{"noindex"}, status: 410},
+			want:          renderedPage{},
+			wantCacheable: true,
+		},
+	}
+	for _, test := range tests {
+		var page renderedPage
+		var statuses pageStatuses
+		cacheable := page.applyRobots(&test.rule, &test.robots, &statuses)
+		page.status = statuses.status()
+		if !reflect.DeepEqual(page, test.want) || cacheable != test.wantCacheable {
+			t.Errorf("%+v with %+v: want %+v (cacheable %v), got %+v (cacheable %v)", test.rule, test.robots, test.want, test.wantCacheable, page, cacheable)
+		}
+	}
+}
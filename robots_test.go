@@ -0,0 +1,43 @@
+package webloop
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRobotsRules(t *testing.T) {
+	robotsTxt := `
+# Comments are ignored.
+User-agent: *
+Disallow: /private/
+Allow: /private/public
+Disallow: /*.json$
+
+User-agent: BadBot
+User-agent: WebLoop
+Disallow: /
+Allow: /docs
+`
+	tests := []struct {
+		agent string
+		path  string
+		want  bool
+	}{
+		{agent: "Mozilla", path: "/", want: true},
+		{agent: "Mozilla", path: "/private/x", want: false},
+		{agent: "Mozilla", path: "/private/public/x", want: true},
+		{agent: "Mozilla", path: "/data.json", want: false},
+		{agent: "Mozilla", path: "/data.json?x", want: true},
+		{agent: "WebLoop/v1", path: "/", want: false},
+		{agent: "WebLoop/v1", path: "/docs/a", want: true},
+	}
+	for _, test := range tests {
+		rules, err := parseRobots(strings.NewReader(robotsTxt), test.agent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rules.allowed(test.path); test.want != got {
+			t.Errorf("agent %q, path %q: want allowed == %v, got %v", test.agent, test.path, test.want, got)
+		}
+	}
+}
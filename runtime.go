@@ -0,0 +1,121 @@
+package webloop
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/gotk3/gotk3/gtk"
+)
+
+// WebKit, like the rest of GTK+, may only be used from the thread that runs
+// the GTK+ main loop. The runtime owns that thread: Start starts the main loop
+// on a dedicated, locked OS thread, and Do runs funcs on it.
+
+var (
+	// runtimeMu protects the fields below.
+	runtimeMu sync.Mutex
+
+	// started is whether Start has been called (and Stop has not).
+	started bool
+
+	// external is whether the main loop was already running, in a thread
+	// that webloop doesn't own, when Start was called.
+	external bool
+
+	// stopped is closed when the main loop started by Start returns.
+	stopped chan struct{}
+)
+
+// Start initializes GTK+ and starts running the GTK+ main loop on a
+// dedicated OS thread. It returns once the main loop is ready to run funcs
+// passed to Do. If the main loop has already been started by Start, Start
+// does nothing. If the program already runs the GTK+ main loop itself (by
+// calling gtk.Main in another goroutine), Start uses that main loop instead
+// of starting another.
+//
+// Do, and therefore all View and Context methods, call Start if necessary, so
+// most programs don't need to call it explicitly.
+func Start() {
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+	if started {
+		return
+	}
+	started = true
+
+	if mainLoopRunning() {
+		external = true
+		return
+	}
+
+	ready := make(chan struct{})
+	stopped = make(chan struct{})
+	go func() {
+		runtime.LockOSThread()
+		defer close(stopped)
+		gtk.Init(nil)
+		close(ready)
+		gtk.Main()
+	}()
+	<-ready
+}
+
+// Stop stops the GTK+ main loop started by Start and waits for it to return.
+// All Views should be closed before calling Stop. If the main loop was started
+// by the program rather than by Start, Stop does not stop it. After Stop
+// returns, Start may be called again.
+func Stop() {
+	runtimeMu.Lock()
+	defer runtimeMu.Unlock()
+	if !started {
+		return
+	}
+	if !external {
+		glib.IdleAdd(func() bool {
+			gtk.MainQuit()
+			return false
+		})
+		<-stopped
+	}
+	started, external, stopped = false, false, nil
+}
+
+// Do runs f on the GTK+ main loop thread and waits for it to return, calling
+// Start first if necessary. If f panics, the panic is recovered (so that it
+// doesn't stop the main loop) and returned as an error. Do may be called from
+// the main loop thread itself (for example, in a signal handler), in which
+// case it calls f directly.
+func Do(f func()) (err error) {
+	if onMainLoopThread() {
+		return call(f)
+	}
+
+	Start()
+	done := make(chan error, 1)
+	glib.IdleAdd(func() bool {
+		done <- call(f)
+		return false
+	})
+	return <-done
+}
+
+// call calls f, returning an error if it panics.
+func call(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("webloop: panic on GTK+ main loop thread: %v", r)
+		}
+	}()
+	f()
+	return nil
+}
+
+// mustDo is like Do, but it panics (in the calling goroutine, not on the main
+// loop thread) if f panics.
+func mustDo(f func()) {
+	if err := Do(f); err != nil {
+		panic(err)
+	}
+}
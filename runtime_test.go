@@ -0,0 +1,46 @@
+package webloop
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDo(t *testing.T) {
+	var ran bool
+	if err := Do(func() { ran = true }); err != nil {
+		t.Fatal(err)
+	}
+	if !ran {
+		t.Error("want func to run")
+	}
+}
+
+func TestDo_panic(t *testing.T) {
+	err := Do(func() { panic("foo") })
+	if err == nil || !strings.Contains(err.Error(), "foo") {
+		t.Errorf("want panic error, got %v", err)
+	}
+
+	// The main loop should still be running.
+	if err := Do(func() {}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDo_nested(t *testing.T) {
+	var ran bool
+	err := Do(func() {
+		if !onMainLoopThread() {
+			t.Error("want func to run on main loop thread")
+		}
+		if err := Do(func() { ran = true }); err != nil {
+			t.Error(err)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ran {
+		t.Error("want nested func to run")
+	}
+}
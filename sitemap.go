@@ -0,0 +1,182 @@
+package webloop
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// MaxSitemapURLs is the maximum number of URLs in a single sitemap file,
+// according to the sitemaps.org protocol. Larger sitemaps are split into
+// multiple files listed in a sitemap index.
+const MaxSitemapURLs = 50000
+
+// SitemapEntry is a page's entry in a sitemap.
+type SitemapEntry struct {
+	// Loc is the page's canonical URL.
+	Loc string `json:"loc"`
+
+	// LastMod is when the page was last modified, in W3C datetime format
+	// (such as "2014-01-31" or "2014-01-31T15:04:05Z"). It is empty if
+	// unknown.
+	LastMod string `json:"lastModified,omitempty"`
+
+	// Alternates are the page's versions in other languages.
+	Alternates []Alternate `json:"alternates,omitempty"`
+}
+
+// Alternate is a version of a page in another language or region, from a
+// <link rel="alternate" hreflang="..."> element.
+type Alternate struct {
+	HrefLang string `json:"hreflang"`
+	Href     string `json:"href"`
+}
+
+// extractSitemapEntryScript returns a JSON object describing the page's
+// canonical URL, last-modified hints and hreflang alternates.
+const extractSitemapEntryScript = `(function() {
+  var canonical = document.querySelector("link[rel=canonical][href]");
+  var lastMod = document.querySelector('meta[property="article:modified_time"], meta[property="og:updated_time"], meta[name="last-modified"], meta[http-equiv="last-modified"]');
+  var alternates = Array.prototype.map.call(document.querySelectorAll("link[rel=alternate][hreflang][href]"), function(link) {
+    return {hreflang: link.getAttribute("hreflang"), href: link.href};
+  });
+  return JSON.stringify({
+    loc: canonical ? canonical.href : location.href.replace(/#.*$/, ""),
+    lastModified: lastMod ? lastMod.getAttribute("content") : "",
+    alternates: alternates
+  });
+})()`
+
+// sitemapEntry returns the sitemap entry for the page currently loaded in v,
+// based on its rendered DOM.
+func (v *View) sitemapEntry() (*SitemapEntry, error) {
+	result, err := v.EvaluateJavaScript(extractSitemapEntryScript)
+	if err != nil {
+		return nil, err
+	}
+	var e SitemapEntry
+	if err := json.Unmarshal([]byte(result.(string)), &e); err != nil {
+		return nil, err
+	}
+	return &e, nil
+}
+
+const sitemapNS = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type xmlURLSet struct {
+	XMLName    xml.Name `xml:"urlset"`
+	XMLNS      string   `xml:"xmlns,attr"`
+	XMLNSXHTML string   `xml:"xmlns:xhtml,attr,omitempty"`
+	URLs       []xmlURL `xml:"url"`
+}
+
+type xmlURL struct {
+	Loc        string    `xml:"loc"`
+	LastMod    string    `xml:"lastmod,omitempty"`
+	Alternates []xmlLink `xml:"xhtml:link"`
+}
+
+type xmlLink struct {
+	Rel      string `xml:"rel,attr"`
+	HrefLang string `xml:"hreflang,attr"`
+	Href     string `xml:"href,attr"`
+}
+
+type xmlSitemapIndex struct {
+	XMLName  xml.Name     `xml:"sitemapindex"`
+	XMLNS    string       `xml:"xmlns,attr"`
+	Sitemaps []xmlSitemap `xml:"sitemap"`
+}
+
+type xmlSitemap struct {
+	Loc string `xml:"loc"`
+}
+
+// WriteSitemap writes a sitemap listing entries to w. It does not split large
+// sitemaps; see WriteSitemaps.
+func WriteSitemap(w io.Writer, entries []*SitemapEntry) error {
+	set := xmlURLSet{XMLNS: sitemapNS}
+	for _, e := range entries {
+		u := xmlURL{Loc: e.Loc, LastMod: e.LastMod}
+		for _, alt := range e.Alternates {
+			u.Alternates = append(u.Alternates, xmlLink{Rel: "alternate", HrefLang: alt.HrefLang, Href: alt.Href})
+		}
+		if len(u.Alternates) > 0 {
+			set.XMLNSXHTML = "http://www.w3.org/1999/xhtml"
+		}
+		set.URLs = append(set.URLs, u)
+	}
+	return writeXML(w, set)
+}
+
+// WriteSitemapIndex writes a sitemap index listing the sitemaps at the given
+// URLs to w.
+func WriteSitemapIndex(w io.Writer, sitemapURLs []string) error {
+	index := xmlSitemapIndex{XMLNS: sitemapNS}
+	for _, u := range sitemapURLs {
+		index.Sitemaps = append(index.Sitemaps, xmlSitemap{Loc: u})
+	}
+	return writeXML(w, index)
+}
+
+// WriteSitemaps writes a sitemap listing entries to dir/sitemap.xml. If there
+// are more than MaxSitemapURLs entries, they are split into files named
+// sitemap-1.xml, sitemap-2.xml, etc., and dir/sitemap.xml is a sitemap index
+// that lists them by their URLs relative to baseURL (the URL at which dir is
+// served).
+func WriteSitemaps(dir, baseURL string, entries []*SitemapEntry) error {
+	if len(entries) <= MaxSitemapURLs {
+		return writeSitemapFile(filepath.Join(dir, "sitemap.xml"), entries)
+	}
+
+	var urls []string
+	for i := 0; i*MaxSitemapURLs < len(entries); i++ {
+		end := (i + 1) * MaxSitemapURLs
+		if end > len(entries) {
+			end = len(entries)
+		}
+		name := fmt.Sprintf("sitemap-%d.xml", i+1)
+		if err := writeSitemapFile(filepath.Join(dir, name), entries[i*MaxSitemapURLs:end]); err != nil {
+			return err
+		}
+		urls = append(urls, strings.TrimSuffix(baseURL, "/")+"/"+name)
+	}
+	f, err := os.Create(filepath.Join(dir, "sitemap.xml"))
+	if err != nil {
+		return err
+	}
+	if err := WriteSitemapIndex(f, urls); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeSitemapFile(name string, entries []*SitemapEntry) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := WriteSitemap(f, entries); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeXML(w io.Writer, v interface{}) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
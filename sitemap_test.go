@@ -0,0 +1,74 @@
+package webloop
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWriteSitemap(t *testing.T) {
+	var buf bytes.Buffer
+	err := WriteSitemap(&buf, []*SitemapEntry{
+		{Loc: "http://example.com/"},
+		{
+			Loc:        "http://example.com/en/about",
+			LastMod:    "2014-01-31",
+			Alternates: []Alternate{{HrefLang: "de", Href: "http://example.com/de/about"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:xhtml="http://www.w3.org/1999/xhtml">
+  <url>
+    <loc>http://example.com/</loc>
+  </url>
+  <url>
+    <loc>http://example.com/en/about</loc>
+    <lastmod>2014-01-31</lastmod>
+    <xhtml:link rel="alternate" hreflang="de" href="http://example.com/de/about"></xhtml:link>
+  </url>
+</urlset>
+`
+	if got := buf.String(); want != got {
+		t.Errorf("want sitemap\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestWriteSitemaps_split(t *testing.T) {
+	dir, err := ioutil.TempDir("", "webloop-sitemap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	entries := make([]*SitemapEntry, MaxSitemapURLs+1)
+	for i := range entries {
+		entries[i] = &SitemapEntry{Loc: "http://example.com/" + strconv.Itoa(i)}
+	}
+	if err := WriteSitemaps(dir, "http://example.com/", entries); err != nil {
+		t.Fatal(err)
+	}
+
+	index, err := ioutil.ReadFile(filepath.Join(dir, "sitemap.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"<sitemapindex", "<loc>http://example.com/sitemap-1.xml</loc>", "<loc>http://example.com/sitemap-2.xml</loc>"} {
+		if !strings.Contains(string(index), want) {
+			t.Errorf("want sitemap index to contain %q, got\n%s", want, index)
+		}
+	}
+	last, err := ioutil.ReadFile(filepath.Join(dir, "sitemap-2.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(last), "<url>"); n != 1 {
+		t.Errorf("want 1 URL in sitemap-2.xml, got %d", n)
+	}
+}
@@ -1,14 +1,22 @@
 package webloop
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
-	"runtime"
+	"net/url"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/gotk3/gotk3/gtk"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // StaticRenderer generates and returns static HTML based on a snapshot of a Web
@@ -24,6 +32,12 @@ type StaticRenderer struct {
 	// window.$renderStaticReady.
 	WaitTimeout time.Duration
 
+	// ReadyExpression is the JavaScript expression that is evaluated
+	// repeatedly after a page loads until it is true, at which point the page
+	// is ready to be rendered. If empty, DefaultReadyExpression is used. To
+	// render pages as soon as they load, use "true".
+	ReadyExpression string
+
 	// ReturnUnfinishedPages is whether a page that has not set
 	// window.$renderStaticReady after WaitTimeout is sent to the browser in a
 	// (potentially) unfinished state. If false, an HTTP 502 Bad Gateway error
@@ -43,90 +57,661 @@ type StaticRenderer struct {
 	// should relied upon for security purposes.
 	RemoveScripts bool
 
+	// TranslateEscapedFragment indicates whether requests that use Google's
+	// AJAX crawling scheme are translated back into their original hashbang
+	// URLs before rendering. A crawler requests the page at
+	// "/path#!/cities/1" as "/path?_escaped_fragment_=/cities/1", and a page
+	// that opts in with <meta name="fragment" content="!"> is requested with
+	// an empty _escaped_fragment_ parameter. The <meta name="fragment"> tag is
+	// removed from the rendered HTML so that crawlers don't request the
+	// snapshot again.
+	TranslateEscapedFragment bool
+
+	// CacheTTL is how long rendered pages are cached and served without being
+	// rendered again. If zero, rendered pages are not cached.
+	CacheTTL time.Duration
+
+	// CacheSize is the maximum number of rendered pages to cache. If zero,
+	// DefaultCacheSize is used.
+	CacheSize int
+
+	// CollectSitemap is whether to record each rendered page's canonical
+	// URL, last-modified hints and hreflang alternates (from its rendered
+	// DOM) in the cache, so that ServeSitemap can list the cached pages.
+	// It has no effect unless CacheTTL is set.
+	CollectSitemap bool
+
+	// RecycleAfterLoads, if non-zero, is the number of pages that the view
+	// loads before it is closed and replaced with a new view (and web
+	// process). Recycling views limits the memory that leaks accumulate in a
+	// long-running web process.
+	RecycleAfterLoads int
+
+	// RecycleAfterAge, if non-zero, is how long the view is used before it is
+	// replaced with a new view.
+	RecycleAfterAge time.Duration
+
+	// RecycleAboveMemory, if non-zero, is the resident memory (in bytes) of
+	// the view's web process above which the view is replaced with a new
+	// view. The memory is only known when the view is the only View in the
+	// program (see View.Health), so RecycleAboveMemory has no effect if
+	// other StaticRenderers, Batches or Views are in use.
+	RecycleAboveMemory uint64
+
+	// ServeMetadata is whether requests with an X-Render-Metadata header
+	// (see MetadataHeader) are answered with the rendered page's Metadata,
+	// as JSON, instead of its HTML. These requests bypass the cache.
+	ServeMetadata bool
+
+	// ServeFormats is whether clients can ask for the rendered page as plain
+	// text, readable text or Markdown (see Format) instead of HTML, with the
+	// _format query parameter (see FormatParameter) or an Accept header
+	// that prefers text/plain, text/plain with ReadableProfile or
+	// text/markdown. Each format is cached separately.
+	ServeFormats bool
+
+	// Robots are the rules for how the robots directives of rendered pages
+	// affect the responses for them. The rule with the longest prefix of
+	// the request's path applies; if none do, robots directives are
+	// ignored.
+	Robots []RobotsRule
+
+	// StatusChecks detect error pages (such as "Not found" pages rendered
+	// client-side) in rendered pages, so that they are served with an HTTP
+	// error status instead of 200 OK. The first check that matches a page
+	// determines its status.
+	//
+	// A page's status is set by the first of these that applies: the
+	// page's StatusCodeVariable (with UseStatusCodeVariable), its <meta
+	// name="prerender-status-code"> (with a RobotsRule's StatusMeta),
+	// StatusChecks, and a RobotsRule's NoindexStatus.
+	StatusChecks []StatusCheck
+
+	// UseStatusCodeVariable is whether pages can set the HTTP status code to
+	// respond with by setting window.$renderStatusCode (see
+	// StatusCodeVariable) to a number. It takes precedence over all other
+	// ways to set the status (see StatusChecks).
+	UseStatusCodeVariable bool
+
+	// TracerProvider, if non-nil, is used to trace renders with OpenTelemetry.
+	// Each render is a span, whose parent is taken from the incoming
+	// request's context or traceparent header, with child spans for loading
+	// the page, waiting for it to become ready (and each readiness check),
+	// each resource the page loads, and serializing its HTML. The render's
+	// trace context is sent to the target in the traceparent header of the
+	// request for the page and of the page's same-origin fetch and
+	// XMLHttpRequest requests. (WebKit doesn't let the headers of other
+	// subresource requests, such as for images and stylesheets, be changed.)
+	TracerProvider trace.TracerProvider
+
 	// Log is the logger to use for log messages. If nil, there is no log
 	// output.
 	Log *log.Logger
 
 	viewLock sync.Mutex
 	view     *View
+
+	cache renderCache
 }
 
-var startGTKOnce sync.Once
+// DefaultReadyExpression is the JavaScript expression that a StaticRenderer
+// waits for to be true before rendering a page, if its ReadyExpression is
+// empty.
+const DefaultReadyExpression = "window.$renderStaticReady"
+
+// MetadataHeader is the request header that asks a StaticRenderer whose
+// ServeMetadata is set for the rendered page's metadata instead of its HTML.
+const MetadataHeader = "X-Render-Metadata"
 
-// StartGTK ensures that the GTK+ main loop has started. If it has already been
-// started by StartGTK, it will not start it again. If another goroutine is
-// already running the GTK+ main loop, StartGTK's behavior is undefined.
+// StartGTK ensures that the GTK+ main loop has started.
+//
+// Deprecated: Use Start, or let the handler start the main loop when it
+// renders its first page.
 func (h *StaticRenderer) StartGTK() {
-	startGTKOnce.Do(func() {
-		gtk.Init(nil)
-		go func() {
-			runtime.LockOSThread()
-			gtk.Main()
-		}()
-	})
+	Start()
 }
 
 // Release releases resources used by this handler, such as the view. If this
 // handler is reused after calling Release, the view is automatically recreated.
+// If a page is being rendered, Release waits for it to finish.
 func (h *StaticRenderer) Release() {
 	h.viewLock.Lock()
-	h.view.Close()
-	h.view = nil
 	defer h.viewLock.Unlock()
+	if h.view != nil {
+		h.view.Close()
+		h.view = nil
+	}
 }
 
 // ServeHTTP implements net/http.Handler.
 func (h *StaticRenderer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	h.StartGTK()
+	metrics := h.Context.metrics()
+	tracer := h.tracer()
+
+	format, reqURL := FormatHTML, r.URL
+	if h.ServeFormats {
+		var ok bool
+		if format, ok = requestFormat(r); !ok {
+			http.Error(w, "Unknown format "+r.URL.Query().Get(FormatParameter), http.StatusBadRequest)
+			return
+		}
+		reqURL = removeQueryParam(r.URL, FormatParameter)
+		w.Header().Add("Vary", "Accept")
+	}
+	targetURL := h.TargetBaseURL + h.targetPath(reqURL)
+	ctx := traceContext.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "webloop.Render", trace.WithAttributes(attribute.String("webloop.target_url", targetURL), attribute.String("webloop.format", string(format))))
+	defer span.End()
+
+	// Pages in formats other than HTML are cached separately.
+	cacheKey := targetURL
+	if format != FormatHTML {
+		cacheKey = string(format) + ":" + targetURL
+	}
+
+	wantMetadata := h.ServeMetadata && r.Header.Get(MetadataHeader) != ""
+	if h.CacheTTL > 0 && !wantMetadata {
+		page, ok := h.cache.get(cacheKey)
+		metrics.CountCacheLookup(ok)
+		span.SetAttributes(attribute.Bool("webloop.cache_hit", ok))
+		if ok {
+			h.logf("Serving cached %s for page at URL: %s", format, targetURL)
+			page.write(w)
+			return
+		}
+	}
+
+	Start()
+	metrics.AddRendersWaiting(1)
 	h.viewLock.Lock()
-	defer h.viewLock.Unlock()
+	metrics.AddRendersWaiting(-1)
+	metrics.AddViewsInUse(1)
+	defer func() {
+		metrics.AddViewsInUse(-1)
+		h.viewLock.Unlock()
+	}()
+
+	// Metadata is extracted from the view, so the page isn't serialized.
+	content, unfinished, err := h.render(ctx, tracer, targetURL, format, !wantMetadata)
+	if rerr, ok := err.(*renderError); ok && rerr.err == ErrWebProcessCrashed {
+		// The view is unusable, so replace it and try once more.
+		h.logf("Web process crashed while rendering page at URL %s; restarting it and retrying", targetURL)
+		h.view.Close()
+		h.view = nil
+		content, unfinished, err = h.render(ctx, tracer, targetURL, format, !wantMetadata)
+	}
+	if err != nil {
+		rerr := err.(*renderError)
+		if rerr.err == ErrWebProcessCrashed {
+			rerr.outcome, rerr.status, rerr.msg = OutcomeWebProcessCrashed, http.StatusBadGateway, "Web process crashed while rendering page"
+			// Don't leave the unusable view for the next request.
+			h.view.Close()
+			h.view = nil
+		}
+		h.logf("Failed to render page at URL %s: %s", targetURL, rerr.err)
+		metrics.CountOutcome(rerr.outcome)
+		span.SetStatus(codes.Error, rerr.err.Error())
+		http.Error(w, rerr.msg, rerr.status)
+		return
+	}
+	if unfinished {
+		metrics.CountOutcome(OutcomeTimeoutUnfinished)
+	} else {
+		metrics.CountOutcome(OutcomeOK)
+	}
+
+	if wantMetadata {
+		md, err := h.view.ExtractMetadata()
+		if err != nil {
+			h.logf("Failed to extract metadata for page at URL %s: %s", targetURL, err)
+			http.Error(w, "Failed to extract page metadata", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		json.NewEncoder(w).Encode(md)
+		return
+	}
+
+	cacheable := !unfinished
+	page := &renderedPage{}
+	if format != FormatHTML {
+		page.contentType = format.contentType()
+	}
+	var statuses pageStatuses
+	if h.UseStatusCodeVariable || len(h.StatusChecks) > 0 {
+		if err := h.detectStatus(h.view, &statuses); err != nil {
+			h.logf("Failed to detect status of page at URL %s: %s", targetURL, err)
+		}
+	}
+	if rule := matchRobotsRule(h.Robots, r.URL.Path); rule != nil {
+		robots, err := h.view.robots()
+		if err != nil {
+			h.logf("Failed to determine robots directives for page at URL %s: %s", targetURL, err)
+		} else if !page.applyRobots(rule, robots, &statuses) {
+			h.logf("Not caching page at URL %s with robots directives %q", targetURL, robots.directives)
+			cacheable = false
+		}
+	}
+	if page.status = statuses.status(); page.status != 0 {
+		h.logf("Serving page at URL %s with status %d", targetURL, page.status)
+	}
+
+	if format == FormatHTML {
+		if h.RemoveScripts {
+			content = strings.Replace(content, "<script", `<script type="text/disabled"`, -1)
+		}
+		if h.TranslateEscapedFragment {
+			content = metaFragmentTag.ReplaceAllString(content, "")
+		}
+	}
+	page.content = content
+	if h.CacheTTL > 0 && cacheable {
+		var sitemap *SitemapEntry
+		if h.CollectSitemap && format == FormatHTML {
+			if sitemap, err = h.view.sitemapEntry(); err != nil {
+				h.logf("Failed to extract sitemap entry for page at URL %s: %s", targetURL, err)
+			} else {
+				sitemap.Loc = h.sitemapLoc(sitemap.Loc, r)
+			}
+		}
+		size := h.CacheSize
+		if size == 0 {
+			size = DefaultCacheSize
+		}
+		h.cache.add(cacheKey, page, sitemap, h.CacheTTL, size)
+	}
+	page.write(w)
+}
+
+// renderedPage is a rendered page as it is served to clients.
+type renderedPage struct {
+	content     string
+	contentType string   // Content-Type header value (if empty, it is sniffed)
+	status      int      // HTTP status code (0 means 200)
+	robots      []string // X-Robots-Tag header values, if any
+}
+
+// write writes the page as an HTTP response.
+func (p *renderedPage) write(w http.ResponseWriter) {
+	if p.contentType != "" {
+		w.Header().Set("Content-Type", p.contentType)
+	}
+	for _, v := range p.robots {
+		w.Header().Add("X-Robots-Tag", v)
+	}
+	if p.status != 0 {
+		w.WriteHeader(p.status)
+	}
+	w.Write([]byte(p.content))
+}
+
+// RecycleReason is why a StaticRenderer replaced its view with a new one.
+type RecycleReason string
+
+const (
+	// RecycleLoads means the view reached RecycleAfterLoads.
+	RecycleLoads RecycleReason = "loads"
+
+	// RecycleAge means the view reached RecycleAfterAge.
+	RecycleAge RecycleReason = "age"
+
+	// RecycleMemory means the view's web process exceeded
+	// RecycleAboveMemory.
+	RecycleMemory RecycleReason = "memory"
+)
+
+// recycleReason returns why a view with the given health should be recycled,
+// or "" if it shouldn't.
+func (h *StaticRenderer) recycleReason(health ViewHealth) RecycleReason {
+	switch {
+	case h.RecycleAfterLoads > 0 && health.Loads >= h.RecycleAfterLoads:
+		return RecycleLoads
+	case h.RecycleAfterAge > 0 && health.Age >= h.RecycleAfterAge:
+		return RecycleAge
+	case h.RecycleAboveMemory > 0 && health.WebProcessMemory > h.RecycleAboveMemory:
+		return RecycleMemory
+	}
+	return ""
+}
+
+// renderError is an error rendering a page, along with how to report it.
+type renderError struct {
+	err     error
+	outcome Outcome
+	status  int    // HTTP status code
+	msg     string // HTTP error message
+}
+
+func (e *renderError) Error() string { return e.err.Error() }
+
+// render loads the page at targetURL in the view (creating the view if
+// necessary), waits for it to become ready, and, if serialize is true, returns
+// it in format. The caller must hold h.viewLock. If the page did not become
+// ready within the wait timeout and ReturnUnfinishedPages is set, unfinished is
+// true. All errors are of type *renderError.
+func (h *StaticRenderer) render(ctx context.Context, tracer trace.Tracer, targetURL string, format Format, serialize bool) (content string, unfinished bool, err error) {
+	metrics := h.Context.metrics()
 
+	if h.view != nil && (h.RecycleAfterLoads > 0 || h.RecycleAfterAge > 0 || h.RecycleAboveMemory > 0) {
+		if reason := h.recycleReason(h.view.Health()); reason != "" {
+			h.logf("Recycling view (%s limit reached)", reason)
+			metrics.CountViewRecycle(reason)
+			h.view.Close()
+			h.view = nil
+		}
+	}
 	if h.view == nil {
 		h.view = h.Context.NewView()
 	}
 
-	targetURL := h.TargetBaseURL + r.URL.String()
-	h.logf("Rendering HTML for page at URL: %s", targetURL)
-	h.view.Open(targetURL)
-	h.view.Wait()
+	h.view.setResourceObserver(func(res Resource) {
+		_, rspan := tracer.Start(ctx, "webloop.Resource", trace.WithTimestamp(res.Started), trace.WithAttributes(attribute.String("http.url", res.URI)))
+		if res.Err != nil {
+			rspan.SetStatus(codes.Error, res.Err.Error())
+		}
+		rspan.End(trace.WithTimestamp(res.Finished))
+	})
+	defer h.view.setResourceObserver(nil)
 
-	// Wait until window.$renderStaticReady is true.
+	h.logf("Rendering HTML for page at URL: %s", targetURL)
 	start := time.Now()
-	for {
-		if time.Since(start) > h.WaitTimeout {
-			if h.ReturnUnfinishedPages {
-				h.logf("Page at URL %s did not set $renderStaticReady within timeout %s; returning unfinished page", targetURL, h.WaitTimeout)
-				break
-			}
-			h.logf("Page at URL %s did not set $renderStaticReady within timeout %s; returning HTTP error", targetURL, h.WaitTimeout)
-			http.Error(w, "No response from origin server within "+h.WaitTimeout.String(), http.StatusBadGateway)
-			return
+	loadCtx, loadSpan := tracer.Start(ctx, "webloop.Load")
+	header := http.Header{}
+	traceContext.Inject(loadCtx, propagation.HeaderCarrier(header))
+	if len(header) > 0 {
+		// The page's requests during the whole render (not just the
+		// load) are children of the render's span.
+		subHeader := http.Header{}
+		traceContext.Inject(ctx, propagation.HeaderCarrier(subHeader))
+		h.view.setSubresourceHeader(subHeader)
+		defer h.view.setSubresourceHeader(nil)
+		h.view.OpenWithHeader(targetURL, header)
+	} else {
+		h.view.Open(targetURL)
+	}
+	err = h.view.Wait()
+	if err != nil {
+		loadSpan.SetStatus(codes.Error, err.Error())
+	}
+	loadSpan.End()
+	metrics.ObservePhase(PhaseLoad, time.Since(start))
+	if err == ErrWebProcessCrashed {
+		return "", false, &renderError{err, OutcomeWebProcessCrashed, http.StatusBadGateway, "Web process crashed while rendering page"}
+	} else if err != nil {
+		// Pages that fail to load are still waited for and rendered
+		// (usually as WebKit's error page, if ReturnUnfinishedPages is
+		// set).
+		h.logf("Failed to load page at URL %s: %s", targetURL, err)
+		metrics.CountOutcome(OutcomeLoadFailed)
+	}
+
+	// Wait until the ready expression (by default, window.$renderStaticReady)
+	// is true.
+	readyExpr := h.ReadyExpression
+	if readyExpr == "" {
+		readyExpr = DefaultReadyExpression
+	}
+	start = time.Now()
+	waitCtx, waitSpan := tracer.Start(ctx, "webloop.Wait", trace.WithAttributes(attribute.String("webloop.ready_expression", readyExpr)))
+	ready, err := waitReady(waitCtx, tracer, h.view, readyExpr, h.WaitTimeout)
+	metrics.ObservePhase(PhaseWait, time.Since(start))
+	if err == nil && !ready {
+		waitSpan.SetStatus(codes.Error, "timeout")
+	}
+	waitSpan.End()
+	if err != nil {
+		return "", false, &renderError{err, OutcomeJavaScriptError, http.StatusInternalServerError, "error checking " + readyExpr + ": " + err.Error()}
+	}
+	if !ready {
+		if !h.ReturnUnfinishedPages {
+			h.logf("Page at URL %s did not set %s within timeout %s; returning HTTP error", targetURL, readyExpr, h.WaitTimeout)
+			return "", false, &renderError{ErrNotReady, OutcomeTimeout502, http.StatusBadGateway, "No response from origin server within " + h.WaitTimeout.String()}
 		}
+		h.logf("Page at URL %s did not set %s within timeout %s; returning unfinished page", targetURL, readyExpr, h.WaitTimeout)
+		unfinished = true
+	}
+	if !serialize {
+		return "", unfinished, nil
+	}
 
-		ready, err := h.view.EvaluateJavaScript("window.$renderStaticReady")
+	start = time.Now()
+	_, serializeSpan := tracer.Start(ctx, "webloop.Serialize")
+	content, err = h.view.Render(format)
+	serializeSpan.End()
+	metrics.ObservePhase(PhaseSerialize, time.Since(start))
+	if err != nil {
+		return "", false, &renderError{err, OutcomeJavaScriptError, http.StatusInternalServerError, ""}
+	}
+	return content, unfinished, nil
+}
+
+// waitReady waits up to timeout for the JavaScript expression expr to be true
+// in v, evaluating it repeatedly. It returns false if expr did not become true
+// in time. Each evaluation is traced as a child span of the span in ctx.
+func waitReady(ctx context.Context, tracer trace.Tracer, v *View, expr string, timeout time.Duration) (ready bool, err error) {
+	start := time.Now()
+	for time.Since(start) <= timeout {
+		_, checkSpan := tracer.Start(ctx, "webloop.ReadyCheck")
+		result, err := v.EvaluateJavaScript(expr)
+		checkSpan.End()
 		if err != nil {
-			http.Error(w, "error checking $renderStaticReady: "+err.Error(), http.StatusInternalServerError)
-			return
+			return false, err
 		}
-		if ready, _ := ready.(bool); ready {
-			break
+		if ready, _ := result.(bool); ready {
+			return true, nil
 		}
 	}
+	return false, nil
+}
+
+// ServeSitemap serves a sitemap.xml listing the pages in the cache (see
+// CollectSitemap), sorted by URL and each listed once. If there are more than
+// MaxSitemapURLs pages, it serves a sitemap index listing sitemaps at the same
+// URL with a "page" query parameter (such as /sitemap.xml?page=2).
+func (h *StaticRenderer) ServeSitemap(w http.ResponseWriter, r *http.Request) {
+	entries := h.cache.sitemapEntries()
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
 
-	result, err := h.view.EvaluateJavaScript("document.documentElement.outerHTML")
+	var err error
+	if page := r.URL.Query().Get("page"); page != "" {
+		n, _ := strconv.Atoi(page)
+		if n < 1 || (n-1)*MaxSitemapURLs >= len(entries) {
+			http.NotFound(w, r)
+			return
+		}
+		end := n * MaxSitemapURLs
+		if end > len(entries) {
+			end = len(entries)
+		}
+		err = WriteSitemap(w, entries[(n-1)*MaxSitemapURLs:end])
+	} else if len(entries) > MaxSitemapURLs {
+		var urls []string
+		base := requestURL(r)
+		if i := strings.Index(base, "?"); i != -1 {
+			base = base[:i]
+		}
+		for i := 0; i*MaxSitemapURLs < len(entries); i++ {
+			urls = append(urls, fmt.Sprintf("%s?page=%d", base, i+1))
+		}
+		err = WriteSitemapIndex(w, urls)
+	} else {
+		err = WriteSitemap(w, entries)
+	}
 	if err != nil {
-		h.logf("Failed to dump HTML for page at URL %s: %s", targetURL, err)
-		http.Error(w, "", http.StatusInternalServerError)
-		return
+		h.logf("Failed to write sitemap: %s", err)
+	}
+}
+
+// sitemapLoc returns the URL to list in the sitemap for the page requested by
+// r, whose canonical URL is canonical. A canonical URL on the target is
+// rewritten to the same path on the host that the client requested. If the
+// page has no (usable) canonical URL, the URL that the client requested is
+// used instead.
+func (h *StaticRenderer) sitemapLoc(canonical string, r *http.Request) string {
+	c, err := url.Parse(canonical)
+	if err != nil || (c.Scheme != "http" && c.Scheme != "https") || c.Host == "" {
+		return requestURL(r)
+	}
+	target, err := url.Parse(h.TargetBaseURL)
+	if err != nil || c.Scheme != target.Scheme || !strings.EqualFold(c.Host, target.Host) {
+		return canonical
 	}
+	basePath := strings.TrimSuffix(target.EscapedPath(), "/")
+	path := c.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if basePath != "" {
+		if path != basePath && !strings.HasPrefix(path, basePath+"/") {
+			// The canonical URL isn't served through the renderer.
+			return requestURL(r)
+		}
+		path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, basePath), "/")
+	}
+	if c.RawQuery != "" {
+		path += "?" + c.RawQuery
+	}
+	return requestOrigin(r) + path
+}
 
-	html := result.(string)
-	if h.RemoveScripts {
-		html = strings.Replace(html, "<script", `<script type="text/disabled"`, -1)
+// requestURL returns the absolute URL that the client requested.
+func requestURL(r *http.Request) string {
+	return requestOrigin(r) + r.URL.RequestURI()
+}
+
+// requestOrigin returns the scheme and host (as in "https://example.com") that
+// the client requested.
+func requestOrigin(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto == "http" || proto == "https" {
+		scheme = proto
+	}
+	return scheme + "://" + r.Host
+}
+
+// traceContext propagates trace context in W3C traceparent headers.
+var traceContext propagation.TraceContext
+
+// subresourceHeaderScript adds the headers in its argument (an object) to the
+// same-origin fetch and XMLHttpRequest requests that the page makes, unless
+// the page sets them itself. Cross-origin requests are left alone, because
+// adding headers to them would require CORS preflight requests that the
+// servers might reject.
+const subresourceHeaderScript = `(function(headers) {
+  function sameOrigin(url) {
+    try { return new URL(url, document.baseURI).origin === location.origin; } catch (e) { return false; }
+  }
+  var xhr = XMLHttpRequest.prototype, open = xhr.open, setRequestHeader = xhr.setRequestHeader, send = xhr.send;
+  xhr.open = function(method, url) {
+    this.$webloopSameOrigin = sameOrigin(url);
+    this.$webloopHeaders = {};
+    return open.apply(this, arguments);
+  };
+  xhr.setRequestHeader = function(name) {
+    if (this.$webloopHeaders) this.$webloopHeaders[String(name).toLowerCase()] = true;
+    return setRequestHeader.apply(this, arguments);
+  };
+  xhr.send = function() {
+    if (this.$webloopSameOrigin) {
+      for (var name in headers) if (!this.$webloopHeaders[name.toLowerCase()]) setRequestHeader.call(this, name, headers[name]);
+    }
+    return send.apply(this, arguments);
+  };
+  if (window.fetch) {
+    var fetch = window.fetch;
+    window.fetch = function(input, init) {
+      var req = new Request(input, init);
+      if (sameOrigin(req.url)) {
+        for (var name in headers) if (!req.headers.has(name)) req.headers.set(name, headers[name]);
+      }
+      return fetch.call(this, req);
+    };
+  }
+})`
+
+// setSubresourceHeader makes the view add the headers in header to the
+// same-origin fetch and XMLHttpRequest requests of the pages that it
+// subsequently loads (see subresourceHeaderScript). If header is empty, no
+// headers are added.
+func (v *View) setSubresourceHeader(header http.Header) {
+	script := ""
+	if len(header) > 0 {
+		headers := map[string]string{}
+		for name := range header {
+			headers[name] = header.Get(name)
+		}
+		data, _ := json.Marshal(headers)
+		script = subresourceHeaderScript + "(" + string(data) + ")"
 	}
-	w.Write([]byte(html))
+	v.do(func() { setUserScript(v.WebView, script) })
 }
 
+func (h *StaticRenderer) tracer() trace.Tracer {
+	tp := h.TracerProvider
+	if tp == nil {
+		tp = trace.NewNoopTracerProvider()
+	}
+	return tp.Tracer("github.com/sourcegraph/webloop")
+}
+
+// targetPath returns the path (and query and fragment) of the target URL to
+// render for a request to u.
+func (h *StaticRenderer) targetPath(u *url.URL) string {
+	if h.TranslateEscapedFragment {
+		return unescapeFragmentURL(u)
+	}
+	return u.String()
+}
+
+// escapedFragmentParam is the query parameter that crawlers use in place of a
+// "#!" URL fragment in Google's AJAX crawling scheme.
+const escapedFragmentParam = "_escaped_fragment_"
+
+// unescapeFragmentURL converts an "ugly" URL that carries its hashbang fragment
+// in the _escaped_fragment_ query parameter back into the "pretty" URL that the
+// JavaScript application expects. Other query parameters are preserved in
+// their original order. If u has no _escaped_fragment_ parameter, it is
+// returned unchanged.
+func unescapeFragmentURL(u *url.URL) string {
+	var (
+		params   []string
+		fragment string
+		found    bool
+	)
+	for _, p := range strings.Split(u.RawQuery, "&") {
+		if p != escapedFragmentParam && !strings.HasPrefix(p, escapedFragmentParam+"=") {
+			if p != "" {
+				params = append(params, p)
+			}
+			continue
+		}
+		v, err := url.QueryUnescape(strings.TrimPrefix(strings.TrimPrefix(p, escapedFragmentParam), "="))
+		if err != nil {
+			return u.String()
+		}
+		fragment, found = v, true
+	}
+	if !found {
+		return u.String()
+	}
+
+	pretty := *u
+	pretty.RawQuery = strings.Join(params, "&")
+	pretty.Fragment = ""
+	s := pretty.String()
+	if fragment != "" {
+		s += "#!" + fragment
+	}
+	return s
+}
+
+// metaFragmentTag matches the <meta name="fragment" content="!"> tag that opts
+// a page without a hashbang URL in to the AJAX crawling scheme.
+var metaFragmentTag = regexp.MustCompile(`(?i)<meta\s+name=["']?fragment["']?\s+content=["']?!["']?\s*/?>`)
+
 func (h *StaticRenderer) logf(msg string, v ...interface{}) {
 	if h.Log != nil {
 		h.Log.Printf(msg, v...)
@@ -0,0 +1,247 @@
+package webloop
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnescapeFragmentURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "/", want: "/"},
+		{url: "/cities?sort=name", want: "/cities?sort=name"},
+		{url: "/?_escaped_fragment_=/cities/1", want: "/#!/cities/1"},
+		{url: "/?_escaped_fragment_=%2Fcities%2F1%3Fq%3Da%26b", want: "/#!/cities/1?q=a&b"},
+		{url: "/app?a=1&_escaped_fragment_=/x&b=2", want: "/app?a=1&b=2#!/x"},
+		{url: "/about?_escaped_fragment_=", want: "/about"},
+		{url: "/about?_escaped_fragment_", want: "/about"},
+	}
+	for _, test := range tests {
+		u, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := unescapeFragmentURL(u); test.want != got {
+			t.Errorf("%s: want %q, got %q", test.url, test.want, got)
+		}
+	}
+}
+
+func TestMetaFragmentTag(t *testing.T) {
+	html := `<html><head><meta charset="utf-8"><meta name="fragment" content="!"></head></html>`
+	want := `<html><head><meta charset="utf-8"></head></html>`
+	if got := metaFragmentTag.ReplaceAllString(html, ""); want != got {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
+
+func TestStaticRenderer_sitemapLoc(t *testing.T) {
+	tests := []struct {
+		targetBaseURL string
+		canonical     string
+		want          string
+	}{
+		// Without a canonical URL, the requested URL is used.
+		{"http://app.internal:3000", "", "http://example.com/a?utm=x"},
+		{"http://app.internal:3000", "/a", "http://example.com/a?utm=x"},
+		// Canonical URLs on the target are moved to the public host.
+		{"http://app.internal:3000", "http://app.internal:3000/b?page=2#top", "http://example.com/b?page=2"},
+		{"http://app.internal:3000", "http://APP.internal:3000", "http://example.com/"},
+		{"http://app.internal:3000/app", "http://app.internal:3000/app/b", "http://example.com/b"},
+		{"http://app.internal:3000/app", "http://app.internal:3000/other", "http://example.com/a?utm=x"},
+		// Other canonical URLs are kept.
+		{"http://app.internal:3000", "https://www.example.com/b", "https://www.example.com/b"},
+		{"http://app.internal:3000", "https://app.internal:3000/b", "https://app.internal:3000/b"},
+		{"http://a.com", "http://a.com.evil/b", "http://a.com.evil/b"},
+	}
+	for _, test := range tests {
+		h := &StaticRenderer{TargetBaseURL: test.targetBaseURL}
+		r := httptest.NewRequest("GET", "http://example.com/a?utm=x", nil)
+		if got := h.sitemapLoc(test.canonical, r); got != test.want {
+			t.Errorf("%s with target %s: want %q, got %q", test.canonical, test.targetBaseURL, test.want, got)
+		}
+	}
+}
+
+func TestStaticRenderer_traceparent(t *testing.T) {
+	setup()
+	defer teardown()
+	var (
+		mu          sync.Mutex
+		traceparent = map[string]string{}
+	)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparent[r.URL.Path] = r.Header.Get("traceparent")
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<html><body><script>
+var xhr = new XMLHttpRequest();
+xhr.open("GET", "/xhr");
+xhr.onload = function() {
+  fetch("/fetch").then(function() { window.$renderStaticReady = true; });
+};
+xhr.send();
+</script></body></html>`))
+		default:
+			w.Write([]byte("ok"))
+		}
+	})
+
+	h := &StaticRenderer{
+		TargetBaseURL: server.URL,
+		Context:       ctx,
+		WaitTimeout:   3 * time.Second,
+	}
+	defer h.Release()
+
+	// With no TracerProvider, the trace context of the incoming request is
+	// propagated as is.
+	const want = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("traceparent", want)
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("want status %d, got %d: %s", http.StatusOK, rw.Code, strings.TrimSpace(rw.Body.String()))
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, path := range []string{"/", "/xhr", "/fetch"} {
+		if got := traceparent[path]; got != want {
+			t.Errorf("%s: want traceparent %q, got %q", path, want, got)
+		}
+	}
+}
+
+// outcomeMetrics records the outcomes of renders.
+type outcomeMetrics struct {
+	nopMetrics
+	mu       sync.Mutex
+	outcomes []Outcome
+}
+
+func (m *outcomeMetrics) CountOutcome(outcome Outcome) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outcomes = append(m.outcomes, outcome)
+}
+
+func TestStaticRenderer_webProcessCrash(t *testing.T) {
+	setup()
+	defer teardown()
+
+	// The page's web process is terminated on the requests for it in
+	// crashOn (counting from 1), and the page never becomes ready on those
+	// requests.
+	var (
+		mu       sync.Mutex
+		requests int
+		crashOn  map[int]bool
+	)
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		requests++
+		crash := crashOn[requests]
+		mu.Unlock()
+		if !crash {
+			w.Write([]byte(`<html><body>ok<script>window.$renderStaticReady = true;</script></body></html>`))
+			return
+		}
+		w.Write([]byte(`<html><body>crash</body></html>`))
+		go Do(func() {
+			for _, v := range downloadViews {
+				terminateWebProcess(v.WebView)
+			}
+		})
+	})
+
+	metrics := &outcomeMetrics{}
+	h := &StaticRenderer{
+		TargetBaseURL: server.URL,
+		Context:       Context{Metrics: metrics},
+		WaitTimeout:   5 * time.Second,
+	}
+	defer h.Release()
+
+	tests := []struct {
+		crashOn      map[int]bool
+		wantStatus   int
+		wantOutcome  Outcome
+		wantRequests int
+	}{
+		// The render is retried once in a new view...
+		{crashOn: map[int]bool{1: true}, wantStatus: http.StatusOK, wantOutcome: OutcomeOK, wantRequests: 2},
+		// ...but not twice...
+		{crashOn: map[int]bool{1: true, 2: true}, wantStatus: http.StatusBadGateway, wantOutcome: OutcomeWebProcessCrashed, wantRequests: 2},
+		// ...and the next request starts in a new view, rather than in
+		// the crashed one.
+		{wantStatus: http.StatusOK, wantOutcome: OutcomeOK, wantRequests: 1},
+	}
+	for i, test := range tests {
+		mu.Lock()
+		requests, crashOn = 0, test.crashOn
+		mu.Unlock()
+		metrics.mu.Lock()
+		metrics.outcomes = nil
+		metrics.mu.Unlock()
+
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+		if rw.Code != test.wantStatus {
+			t.Errorf("%d: want status %d, got %d: %s", i, test.wantStatus, rw.Code, strings.TrimSpace(rw.Body.String()))
+		}
+		if h.view != nil && h.view.Crashed() {
+			t.Errorf("%d: crashed view kept for the next request", i)
+		}
+		metrics.mu.Lock()
+		if want := []Outcome{test.wantOutcome}; !reflect.DeepEqual(metrics.outcomes, want) {
+			t.Errorf("%d: want outcomes %v, got %v", i, want, metrics.outcomes)
+		}
+		metrics.mu.Unlock()
+		mu.Lock()
+		if requests != test.wantRequests {
+			t.Errorf("%d: want page requested %d times, got %d", i, test.wantRequests, requests)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestStaticRenderer_loadFailed(t *testing.T) {
+	// Nothing listens on the target's address, so connections to it are
+	// refused.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	targetURL := "http://" + l.Addr().String()
+	l.Close()
+
+	metrics := &outcomeMetrics{}
+	h := &StaticRenderer{
+		TargetBaseURL:         targetURL,
+		Context:               Context{Metrics: metrics},
+		WaitTimeout:           100 * time.Millisecond,
+		ReturnUnfinishedPages: true,
+	}
+	defer h.Release()
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest("GET", "/", nil))
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	if want := []Outcome{OutcomeLoadFailed, OutcomeTimeoutUnfinished}; !reflect.DeepEqual(metrics.outcomes, want) {
+		t.Errorf("want outcomes %v, got %v", want, metrics.outcomes)
+	}
+}
@@ -0,0 +1,136 @@
+package webloop
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// StatusCheck detects rendered pages that should be served with an HTTP error
+// status, such as the "Not found" pages that single-page applications render
+// client-side while the server responds 200 OK for every route. Search
+// engines treat these pages as "soft 404s". A check matches a page if all of
+// its conditions that are set match; a check with no conditions matches no
+// pages.
+type StatusCheck struct {
+	// Expression, if set, is a JavaScript expression that is true on
+	// matching pages.
+	Expression string
+
+	// Selector, if set, is a CSS selector that matches an element on
+	// matching pages.
+	Selector string
+
+	// Title, if non-nil, matches the titles of matching pages.
+	Title *regexp.Regexp
+
+	// Status is the HTTP status code to respond with for matching pages. If
+	// zero, 404 Not Found is used.
+	Status int
+}
+
+// StatusCodeVariable is the JavaScript variable that a page can set to the
+// HTTP status code to respond with, if the StaticRenderer's
+// UseStatusCodeVariable is set.
+const StatusCodeVariable = "window.$renderStatusCode"
+
+// status returns the HTTP status code for a page that matches the check.
+func (c *StatusCheck) status() int {
+	if c.Status == 0 {
+		return http.StatusNotFound
+	}
+	return c.Status
+}
+
+// script returns a JavaScript expression that is true if the page matches the
+// check's expression and selector conditions.
+func (c *StatusCheck) script() string {
+	conds := []string{"true"}
+	if c.Expression != "" {
+		conds = append(conds, "!!("+c.Expression+")")
+	}
+	if c.Selector != "" {
+		sel, _ := json.Marshal(c.Selector)
+		conds = append(conds, "!!document.querySelector("+string(sel)+")")
+	}
+	return strings.Join(conds, " && ")
+}
+
+// matches reports whether the page currently loaded in v matches the check.
+func (c *StatusCheck) matches(v *View) (bool, error) {
+	if c.Expression == "" && c.Selector == "" && c.Title == nil {
+		return false, nil
+	}
+	if c.Title != nil && !c.Title.MatchString(v.Title()) {
+		return false, nil
+	}
+	if c.Expression == "" && c.Selector == "" {
+		return true, nil
+	}
+	result, err := v.EvaluateJavaScript(c.script())
+	if err != nil {
+		return false, err
+	}
+	match, _ := result.(bool)
+	return match, nil
+}
+
+// pageStatuses are the HTTP status codes that a rendered page may be served
+// with, from each of the ways to set it, or 0 for those that don't apply.
+type pageStatuses struct {
+	variable int // from StatusCodeVariable, if UseStatusCodeVariable is set
+	meta     int // from <meta name="prerender-status-code">, if the RobotsRule's StatusMeta is set
+	check    int // from the first matching StatusCheck
+	noindex  int // the RobotsRule's NoindexStatus, if the page is noindex
+}
+
+// status returns the status that the page is served with: the one set by the
+// page itself (with StatusCodeVariable, then with <meta
+// name="prerender-status-code">), then the one detected by StatusChecks, then
+// the one for noindex pages. It returns 0 if none apply.
+func (s *pageStatuses) status() int {
+	for _, status := range []int{s.variable, s.meta, s.check, s.noindex} {
+		if status != 0 {
+			return status
+		}
+	}
+	return 0
+}
+
+// detectStatus sets the statuses from the handler's UseStatusCodeVariable and
+// StatusChecks for the page currently loaded in the view. The StatusChecks
+// aren't run if the page sets StatusCodeVariable, which takes precedence.
+func (h *StaticRenderer) detectStatus(v *View, s *pageStatuses) error {
+	if h.UseStatusCodeVariable {
+		result, err := v.EvaluateJavaScript("typeof " + StatusCodeVariable + ` === "number" ? ` + StatusCodeVariable + " : 0")
+		if err != nil {
+			return err
+		}
+		if s.variable = statusCode(result); s.variable != 0 {
+			return nil
+		}
+	}
+	for i := range h.StatusChecks {
+		c := &h.StatusChecks[i]
+		match, err := c.matches(v)
+		if err != nil {
+			return err
+		}
+		if match {
+			s.check = c.status()
+			return nil
+		}
+	}
+	return nil
+}
+
+// statusCode returns the HTTP status code in the JavaScript result, or 0 if it
+// isn't a valid status code.
+func statusCode(result interface{}) int {
+	f, ok := result.(float64)
+	if !ok || f != float64(int(f)) || f < 100 || f > 599 {
+		return 0
+	}
+	return int(f)
+}
@@ -0,0 +1,116 @@
+package webloop
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestStatusCheck_script(t *testing.T) {
+	tests := []struct {
+		check StatusCheck
+		want  string
+	}{
+		{StatusCheck{}, "true"},
+		{StatusCheck{Expression: "window.notFound"}, "true && !!(window.notFound)"},
+		{StatusCheck{Selector: `div[data-error="404"]`}, `true && !!document.querySelector("div[data-error=\"404\"]")`},
+	}
+	for _, test := range tests {
+		if got := test.check.script(); got != test.want {
+			t.Errorf("%+v: want %q, got %q", test.check, test.want, got)
+		}
+	}
+}
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		result interface{}
+		want   int
+	}{
+		{float64(404), 404},
+		{float64(0), 0},
+		{float64(404.5), 0},
+		{float64(1000), 0},
+		{"404", 0},
+		{nil, 0},
+	}
+	for _, test := range tests {
+		if got := statusCode(test.result); got != test.want {
+			t.Errorf("%#v: want %d, got %d", test.result, test.want, got)
+		}
+	}
+}
+
+func TestPageStatuses_status(t *testing.T) {
+	tests := []struct {
+		statuses pageStatuses
+		want     int
+	}{
+		{pageStatuses{}, 0},
+		{pageStatuses{variable: 403, meta: 451, check: 404, noindex: 410}, 403},
+		{pageStatuses{meta: 451, check: 404, noindex: 410}, 451},
+		{pageStatuses{check: 404, noindex: 410}, 404},
+		{pageStatuses{noindex: 410}, 410},
+	}
+	for _, test := range tests {
+		if got := test.statuses.status(); got != test.want {
+			t.Errorf("%+v: want %d, got %d", test.statuses, test.want, got)
+		}
+	}
+}
+
+func TestStaticRenderer_StatusChecks(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/missing":
+			w.Write([]byte(`<html><head><title>Page not found</title></head><body></body></html>`))
+		case "/gone":
+			w.Write([]byte(`<html><body><div class="error-410"></div></body></html>`))
+		case "/variable":
+			w.Write([]byte(`<html><head><meta name="prerender-status-code" content="451"><meta name="robots" content="noindex"></head><body><script>window.$renderStatusCode = 403;</script></body></html>`))
+		case "/meta":
+			w.Write([]byte(`<html><head><title>Page not found</title><meta name="prerender-status-code" content="451"></head><body></body></html>`))
+		case "/noindex-missing":
+			w.Write([]byte(`<html><head><title>Page not found</title><meta name="robots" content="noindex"></head><body></body></html>`))
+		case "/noindex":
+			w.Write([]byte(`<html><head><meta name="robots" content="noindex"></head><body></body></html>`))
+		default:
+			w.Write([]byte(`<html><head><title>Home</title></head><body></body></html>`))
+		}
+	})
+
+	h := &StaticRenderer{
+		TargetBaseURL:   server.URL,
+		Context:         ctx,
+		ReadyExpression: "true",
+		StatusChecks: []StatusCheck{
+			{Title: regexp.MustCompile(`(?i)not found`)},
+			{Selector: ".error-410", Status: http.StatusGone},
+		},
+		UseStatusCodeVariable: true,
+		Robots:                []RobotsRule{{Prefix: "/", StatusMeta: true, NoindexStatus: http.StatusGone}},
+	}
+	defer h.Release()
+
+	// Statuses set by the page take precedence over StatusChecks, which
+	// take precedence over the status for noindex pages.
+	tests := map[string]int{
+		"/":                http.StatusOK,
+		"/missing":         http.StatusNotFound,
+		"/gone":            http.StatusGone,
+		"/variable":        http.StatusForbidden,
+		"/meta":            http.StatusUnavailableForLegalReasons,
+		"/noindex-missing": http.StatusNotFound,
+		"/noindex":         http.StatusGone,
+	}
+	for path, want := range tests {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+		if rw.Code != want {
+			t.Errorf("%s: want status %d, got %d", path, want, rw.Code)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package webloop
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ErrWaitTimeout indicates that the condition a View was waiting for did not
+// occur within the timeout.
+var ErrWaitTimeout = errors.New("timed out waiting")
+
+// selectorPollInterval is how often WaitForSelector checks for the element.
+const selectorPollInterval = 50 * time.Millisecond
+
+// WaitForNavigation waits for the view to navigate to another URI, either by
+// loading a page or by an in-page navigation (such as history.pushState in a
+// single-page application). If the navigation loads a page, it waits for the
+// load to finish. Only navigations that occur after WaitForNavigation is
+// called are waited for, so to wait for a navigation caused by a script, call
+// WaitForNavigation in a separate goroutine before running the script (or use
+// WaitForURL).
+func (v *View) WaitForNavigation(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	v.mu.Lock()
+	navs := v.navs
+	v.mu.Unlock()
+	if err := v.waitFor(deadline, func() (bool, time.Duration) { return v.navs != navs, 0 }); err != nil {
+		return err
+	}
+	return v.waitLoaded(deadline)
+}
+
+// WaitForURL waits until the view's URI matches pattern, which may already be
+// the case, and then until any page load in progress finishes.
+func (v *View) WaitForURL(pattern *regexp.Regexp, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	if err := v.waitFor(deadline, func() (bool, time.Duration) { return pattern.MatchString(v.uri), 0 }); err != nil {
+		return err
+	}
+	return v.waitLoaded(deadline)
+}
+
+// WaitForNetworkIdle waits until no more than maxInflight of the resources
+// that the page loads (including XMLHttpRequests and fetches) are in flight,
+// and no resource loads have started or finished for quietPeriod.
+func (v *View) WaitForNetworkIdle(quietPeriod time.Duration, maxInflight int, timeout time.Duration) error {
+	return v.waitFor(time.Now().Add(timeout), func() (bool, time.Duration) {
+		if v.inflight > maxInflight {
+			return false, 0
+		}
+		if quiet := time.Since(v.networkChanged); quiet < quietPeriod {
+			return false, quietPeriod - quiet
+		}
+		return true, 0
+	})
+}
+
+// WaitForSelector waits until the page contains an element matching the CSS
+// selector. If visible is true, it also waits until the element is visible
+// (that is, it has a nonzero size and isn't hidden by its style).
+func (v *View) WaitForSelector(selector string, visible bool, timeout time.Duration) error {
+	sel, err := json.Marshal(selector)
+	if err != nil {
+		return err
+	}
+	script := `(function(sel, visible) {
+  var e = document.querySelector(sel);
+  if (!e) return false;
+  if (!visible) return true;
+  var style = window.getComputedStyle(e), rect = e.getBoundingClientRect();
+  return style.display !== "none" && style.visibility !== "hidden" && rect.width > 0 && rect.height > 0;
+})(` + string(sel) + `, ` + strconv.FormatBool(visible) + `)`
+
+	deadline := time.Now().Add(timeout)
+	for {
+		found, err := v.EvaluateJavaScript(script)
+		if err != nil {
+			return err
+		}
+		if found, _ := found.(bool); found {
+			return nil
+		}
+		if time.Now().Add(selectorPollInterval).After(deadline) {
+			return ErrWaitTimeout
+		}
+		time.Sleep(selectorPollInterval)
+	}
+}
+
+// waitLoaded waits until the view isn't loading a page.
+func (v *View) waitLoaded(deadline time.Time) error {
+	// WebKit may notify that the URI changed before it notifies that
+	// loading started, in the same main loop iteration. Wait until the main
+	// loop has run the handlers for both.
+	if err := v.do(func() {}); err != nil {
+		return err
+	}
+	return v.waitFor(deadline, func() (bool, time.Duration) { return !v.loading, 0 })
+}
+
+// waitFor waits until cond returns true, calling it (with v.mu held) each time
+// the view's navigation or network state changes. If cond returns false and a
+// nonzero recheck duration, it is also called again after that duration.
+func (v *View) waitFor(deadline time.Time, cond func() (ok bool, recheck time.Duration)) error {
+	for {
+		v.mu.Lock()
+		ok, recheck := cond()
+		changed := v.changed
+		v.mu.Unlock()
+		if ok {
+			return nil
+		}
+
+		wait := deadline.Sub(time.Now())
+		if wait <= 0 {
+			return ErrWaitTimeout
+		}
+		if recheck > 0 && recheck < wait {
+			wait = recheck
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-changed:
+		case <-timer.C:
+		case <-v.crashed:
+			timer.Stop()
+			return ErrWebProcessCrashed
+		case <-v.closed:
+			timer.Stop()
+			return ErrViewClosed
+		}
+		timer.Stop()
+	}
+}
+
+// stateChanged wakes up the goroutines waiting in waitFor. The caller must
+// hold v.mu.
+func (v *View) stateChanged() {
+	close(v.changed)
+	v.changed = make(chan struct{})
+}
+
+// navigated is called on the GTK+ thread when the view's URI changes.
+func (v *View) navigated(uri string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.navs++
+	v.uri = uri
+	v.stateChanged()
+}
+
+// loadingChanged is called on the GTK+ thread when the view starts or stops
+// loading a page. WebKit considers the view loading as soon as a load is
+// requested, but emits no load-changed signals for moves between history
+// entries of the same document (such as those created by pushState or by
+// following a link to a fragment), so a requested load that stops loading
+// without starting is finished here.
+func (v *View) loadingChanged(loading bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.loading = loading
+	if !loading && v.load != nil && v.load.requested && !v.load.started {
+		v.load.finish(nil)
+	}
+	v.stateChanged()
+}
+
+// pageCommitted is called on the GTK+ thread when the view commits a new page
+// load. The previous page's resources no longer count as in flight, because
+// WebKit doesn't always report that they finished once the page is unloaded.
+func (v *View) pageCommitted() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.page++
+	v.inflight = 0
+	v.networkChanged = time.Now()
+	v.stateChanged()
+}
+
+// resourceStarted is called on the GTK+ thread when the view starts loading a
+// resource. It returns the page that the resource belongs to, for
+// resourceFinished.
+func (v *View) resourceStarted() (page int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.inflight++
+	v.networkChanged = time.Now()
+	v.stateChanged()
+	return v.page
+}
+
+// resourceFinished is called on the GTK+ thread when the view finishes (or
+// fails) loading a resource that resourceStarted said belongs to page.
+func (v *View) resourceFinished(page int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if page != v.page {
+		return
+	}
+	v.inflight--
+	v.networkChanged = time.Now()
+	v.stateChanged()
+}
@@ -0,0 +1,178 @@
+package webloop
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"testing"
+	"time"
+)
+
+// spaHTML is a single-page application whose show function navigates to an
+// item (with history.pushState) and displays it.
+const spaHTML = `<html><body><div id="app"></div><script>
+function show(n) {
+  history.pushState(null, "", "/items/" + n);
+  var e = document.createElement("p");
+  e.id = "item";
+  e.textContent = "item " + n;
+  document.getElementById("app").appendChild(e);
+}
+</script></body></html>`
+
+func TestView_WaitForURL(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(spaHTML))
+	})
+
+	view := ctx.NewView()
+	defer view.Close()
+	view.Open(server.URL)
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// WaitForURL also returns if the URL already matches, so it doesn't
+	// matter whether the navigation happens before or after it is called.
+	errc := make(chan error)
+	go func() {
+		errc <- view.WaitForURL(regexp.MustCompile(`/items/\d+$`), 5*time.Second)
+	}()
+	if _, err := view.EvaluateJavaScript("show(1)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if err := view.WaitForURL(regexp.MustCompile(`/never$`), 100*time.Millisecond); err != ErrWaitTimeout {
+		t.Errorf("want ErrWaitTimeout, got %v", err)
+	}
+}
+
+func TestView_WaitForNavigation(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(spaHTML))
+	})
+
+	view := ctx.NewView()
+	defer view.Close()
+	view.Open(server.URL)
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// WaitForNavigation only waits for navigations after it is called, so
+	// keep navigating until it returns.
+	errc := make(chan error, 1)
+	go func() {
+		errc <- view.WaitForNavigation(5 * time.Second)
+	}()
+	for n := 1; ; n++ {
+		if _, err := view.EvaluateJavaScript(fmt.Sprintf("show(%d)", n)); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-errc:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want, got := fmt.Sprintf("%s/items/%d", server.URL, n), view.URI(); want != got {
+				t.Errorf("want URI %q, got %q", want, got)
+			}
+			return
+		default:
+		}
+	}
+}
+
+func TestView_WaitForSelector(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(spaHTML + `<div id="hidden" style="display: none">x</div>`))
+	})
+
+	view := ctx.NewView()
+	defer view.Close()
+	view.Open(server.URL)
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	errc := make(chan error)
+	go func() {
+		errc <- view.WaitForSelector("#item", true, 5*time.Second)
+	}()
+	if _, err := view.EvaluateJavaScript("show(1)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if err := view.WaitForSelector("#hidden", false, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if err := view.WaitForSelector("#hidden", true, 100*time.Millisecond); err != ErrWaitTimeout {
+		t.Errorf("want ErrWaitTimeout for hidden element, got %v", err)
+	}
+}
+
+func TestView_WaitForNetworkIdle(t *testing.T) {
+	setup()
+	defer teardown()
+	requested, release := make(chan struct{}), make(chan struct{})
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`<script>fetch("/slow").then(function() { window.fetched = true; });</script>`))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, _ *http.Request) {
+		close(requested)
+		<-release
+	})
+
+	view := ctx.NewView()
+	defer view.Close()
+	view.Open(server.URL)
+	if err := view.Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	<-requested
+	if err := view.WaitForNetworkIdle(0, 0, 100*time.Millisecond); err != ErrWaitTimeout {
+		t.Errorf("while fetching: want ErrWaitTimeout, got %v", err)
+	}
+	if err := view.WaitForNetworkIdle(0, 1, time.Second); err != nil {
+		t.Errorf("while fetching, with 1 in flight allowed: %s", err)
+	}
+
+	close(release)
+	if err := view.WaitForNetworkIdle(100*time.Millisecond, 0, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if fetched, _ := view.EvaluateJavaScript("window.fetched === true"); fetched != true {
+		t.Error("want fetch to have finished when the network is idle")
+	}
+}
+
+func TestView_pageCommitted(t *testing.T) {
+	v := &View{changed: make(chan struct{})}
+	old1, old2 := v.resourceStarted(), v.resourceStarted()
+	v.pageCommitted()
+	if v.inflight != 0 {
+		t.Errorf("after commit: want 0 in flight, got %d", v.inflight)
+	}
+
+	cur := v.resourceStarted()
+	v.resourceFinished(old1)
+	v.resourceFinished(old2)
+	if v.inflight != 1 {
+		t.Errorf("after the previous page's resources finished: want 1 in flight, got %d", v.inflight)
+	}
+	v.resourceFinished(cur)
+	if v.inflight != 0 {
+		t.Errorf("after the current page's resource finished: want 0 in flight, got %d", v.inflight)
+	}
+}
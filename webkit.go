@@ -0,0 +1,458 @@
+package webloop
+
+// #cgo pkg-config: webkit2gtk-4.0
+// #include <stdlib.h>
+// #include <webkit2/webkit2.h>
+//
+// static gboolean is_client_certificate_request(WebKitAuthenticationRequest *req) {
+// #if WEBKIT_CHECK_VERSION(2, 34, 0)
+// 	return webkit_authentication_request_get_scheme(req) == WEBKIT_AUTHENTICATION_SCHEME_CLIENT_CERTIFICATE_REQUESTED;
+// #else
+// 	return FALSE;
+// #endif
+// }
+//
+// static WebKitCredential *credential_new_for_certificate_files(const char *cert_file, const char *key_file, GError **error) {
+// #if WEBKIT_CHECK_VERSION(2, 34, 0)
+// 	GTlsCertificate *cert = g_tls_certificate_new_from_files(cert_file, key_file, error);
+// 	if (!cert)
+// 		return NULL;
+// 	WebKitCredential *cred = webkit_credential_new_for_certificate(cert, WEBKIT_CREDENTIAL_PERSISTENCE_FOR_SESSION);
+// 	g_object_unref(cert);
+// 	return cred;
+// #else
+// 	g_set_error_literal(error, G_IO_ERROR, G_IO_ERROR_NOT_SUPPORTED, "client certificates require WebKitGTK+ 2.34 or newer");
+// 	return NULL;
+// #endif
+// }
+//
+// static gboolean verify_certificate_with_ca_file(GTlsCertificate *cert, const char *host, const char *ca_file, GError **error) {
+// 	GList *cas = g_tls_certificate_list_new_from_file(ca_file, error);
+// 	if (!cas)
+// 		return FALSE;
+// 	GSocketConnectable *identity = g_network_address_new(host, 0);
+// 	gboolean ok = FALSE;
+// 	for (GList *ca = cas; ca && !ok; ca = ca->next)
+// 		ok = g_tls_certificate_verify(cert, identity, G_TLS_CERTIFICATE(ca->data)) == 0;
+// 	g_object_unref(identity);
+// 	g_list_free_full(cas, g_object_unref);
+// 	return ok;
+// }
+//
+// // async_data is the result of an asynchronous operation that returns data.
+// typedef struct {
+// 	gboolean done;
+// 	guchar *data;
+// 	gsize length;
+// 	GError *error;
+// } async_data;
+//
+// static void view_saved(GObject *view, GAsyncResult *res, gpointer p) {
+// 	async_data *r = p;
+// 	GInputStream *in = webkit_web_view_save_finish(WEBKIT_WEB_VIEW(view), res, &r->error);
+// 	if (in) {
+// 		GOutputStream *out = g_memory_output_stream_new_resizable();
+// 		if (g_output_stream_splice(out, in, G_OUTPUT_STREAM_SPLICE_CLOSE_SOURCE | G_OUTPUT_STREAM_SPLICE_CLOSE_TARGET, NULL, &r->error) >= 0) {
+// 			r->length = g_memory_output_stream_get_data_size(G_MEMORY_OUTPUT_STREAM(out));
+// 			r->data = g_memory_output_stream_steal_data(G_MEMORY_OUTPUT_STREAM(out));
+// 		}
+// 		g_object_unref(out);
+// 		g_object_unref(in);
+// 	}
+// 	r->done = TRUE;
+// }
+//
+// static async_data *save_mhtml(WebKitWebView *view) {
+// 	async_data *r = g_new0(async_data, 1);
+// 	webkit_web_view_save(view, WEBKIT_SAVE_MODE_MHTML, NULL, view_saved, r);
+// 	return r;
+// }
+//
+// static void resource_data_received(GObject *resource, GAsyncResult *res, gpointer p) {
+// 	async_data *r = p;
+// 	r->data = webkit_web_resource_get_data_finish(WEBKIT_WEB_RESOURCE(resource), res, &r->length, &r->error);
+// 	r->done = TRUE;
+// }
+//
+// static async_data *get_resource_data(WebKitWebResource *resource) {
+// 	async_data *r = g_new0(async_data, 1);
+// 	webkit_web_resource_get_data(resource, NULL, resource_data_received, r);
+// 	return r;
+// }
+//
+// static gboolean is_download_response(WebKitResponsePolicyDecision *decision) {
+// 	if (!webkit_response_policy_decision_is_mime_type_supported(decision))
+// 		return TRUE;
+// 	SoupMessageHeaders *h = webkit_uri_response_get_http_headers(webkit_response_policy_decision_get_response(decision));
+// 	if (!h)
+// 		return FALSE;
+// 	const char *disposition = soup_message_headers_get_one(h, "Content-Disposition");
+// 	return disposition && g_ascii_strncasecmp(disposition, "attachment", 10) == 0;
+// }
+import "C"
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"unsafe"
+
+	"github.com/gotk3/gotk3/glib"
+	"github.com/sourcegraph/go-webkit2/webkit2"
+)
+
+// This file wraps the parts of the WebKitGTK+ API that go-webkit2 doesn't.
+// Like the rest of the WebKit API, these functions must be called on the GTK+
+// main loop thread.
+
+// webViewPtr returns the underlying WebKitWebView of v.
+func webViewPtr(v *webkit2.WebView) *C.WebKitWebView {
+	return (*C.WebKitWebView)(unsafe.Pointer(v.Native()))
+}
+
+// loadURIWithHeader starts loading uri in v, adding the headers in header to
+// the request for the main resource.
+func loadURIWithHeader(v *webkit2.WebView, uri string, header http.Header) {
+	curi := C.CString(uri)
+	defer C.free(unsafe.Pointer(curi))
+	req := C.webkit_uri_request_new((*C.gchar)(curi))
+	defer C.g_object_unref(C.gpointer(req))
+
+	// Non-HTTP URIs have no headers.
+	if h := C.webkit_uri_request_get_http_headers(req); h != nil {
+		for name, values := range header {
+			cname := C.CString(name)
+			for _, value := range values {
+				cvalue := C.CString(value)
+				C.soup_message_headers_append(h, cname, cvalue)
+				C.free(unsafe.Pointer(cvalue))
+			}
+			C.free(unsafe.Pointer(cname))
+		}
+	}
+	C.webkit_web_view_load_request(webViewPtr(v), req)
+}
+
+func canGoBack(v *webkit2.WebView) bool {
+	return C.webkit_web_view_can_go_back(webViewPtr(v)) != C.FALSE
+}
+
+func goBack(v *webkit2.WebView) {
+	C.webkit_web_view_go_back(webViewPtr(v))
+}
+
+func canGoForward(v *webkit2.WebView) bool {
+	return C.webkit_web_view_can_go_forward(webViewPtr(v)) != C.FALSE
+}
+
+func goForward(v *webkit2.WebView) {
+	C.webkit_web_view_go_forward(webViewPtr(v))
+}
+
+// reload reloads the current page in v, revalidating cached resources with the
+// server unless bypassCache is true.
+func reload(v *webkit2.WebView, bypassCache bool) {
+	if bypassCache {
+		C.webkit_web_view_reload_bypass_cache(webViewPtr(v))
+	} else {
+		C.webkit_web_view_reload(webViewPtr(v))
+	}
+}
+
+func stopLoading(v *webkit2.WebView) {
+	C.webkit_web_view_stop_loading(webViewPtr(v))
+}
+
+// isCancelledError reports whether the GError err (passed to a signal handler
+// as a boxed value) is WEBKIT_NETWORK_ERROR_CANCELLED.
+func isCancelledError(err uintptr) bool {
+	return err != 0 && C.g_error_matches((*C.GError)(unsafe.Pointer(err)), C.webkit_network_error_quark(), C.WEBKIT_NETWORK_ERROR_CANCELLED) != C.FALSE
+}
+
+// gerrorMessage returns the message of the GError err (passed to a signal
+// handler as a boxed value), which it doesn't free.
+func gerrorMessage(err uintptr) string {
+	if err == 0 {
+		return ""
+	}
+	return C.GoString((*C.char)((*C.GError)(unsafe.Pointer(err)).message))
+}
+
+// gerror converts err to a Go error and frees it.
+func gerror(err *C.GError) error {
+	defer C.g_error_free(err)
+	return errors.New(C.GoString((*C.char)(err.message)))
+}
+
+func authenticationRequestPtr(req *glib.Object) *C.WebKitAuthenticationRequest {
+	return (*C.WebKitAuthenticationRequest)(unsafe.Pointer(req.Native()))
+}
+
+// authenticationRequest returns the host and port of the server that made the
+// WebKitAuthenticationRequest req, whether it requested a client certificate
+// (rather than a password), and whether it is a retry after the previous
+// credentials were rejected.
+func authenticationRequest(req *glib.Object) (host string, port int, clientCert, retry bool) {
+	r := authenticationRequestPtr(req)
+	host = C.GoString((*C.char)(C.webkit_authentication_request_get_host(r)))
+	port = int(C.webkit_authentication_request_get_port(r))
+	clientCert = C.is_client_certificate_request(r) != C.FALSE
+	retry = C.webkit_authentication_request_is_retry(r) != C.FALSE
+	return host, port, clientCert, retry
+}
+
+func authenticateWithPassword(req *glib.Object, username, password string) {
+	cusername, cpassword := C.CString(username), C.CString(password)
+	defer C.free(unsafe.Pointer(cusername))
+	defer C.free(unsafe.Pointer(cpassword))
+	cred := C.webkit_credential_new((*C.gchar)(cusername), (*C.gchar)(cpassword), C.WEBKIT_CREDENTIAL_PERSISTENCE_FOR_SESSION)
+	defer C.webkit_credential_free(cred)
+	C.webkit_authentication_request_authenticate(authenticationRequestPtr(req), cred)
+}
+
+func authenticateWithCertificate(req *glib.Object, certFile, keyFile string) error {
+	if keyFile == "" {
+		keyFile = certFile
+	}
+	ccertFile, ckeyFile := C.CString(certFile), C.CString(keyFile)
+	defer C.free(unsafe.Pointer(ccertFile))
+	defer C.free(unsafe.Pointer(ckeyFile))
+	var err *C.GError
+	cred := C.credential_new_for_certificate_files(ccertFile, ckeyFile, &err)
+	if cred == nil {
+		return gerror(err)
+	}
+	defer C.webkit_credential_free(cred)
+	C.webkit_authentication_request_authenticate(authenticationRequestPtr(req), cred)
+	return nil
+}
+
+func cancelAuthentication(req *glib.Object) {
+	C.webkit_authentication_request_cancel(authenticationRequestPtr(req))
+}
+
+// verifyCertificate reports whether the GTlsCertificate cert is valid for host
+// when the certificate authorities in caFile are trusted.
+func verifyCertificate(cert *glib.Object, host, caFile string) (bool, error) {
+	chost, ccaFile := C.CString(host), C.CString(caFile)
+	defer C.free(unsafe.Pointer(chost))
+	defer C.free(unsafe.Pointer(ccaFile))
+	var err *C.GError
+	ok := C.verify_certificate_with_ca_file((*C.GTlsCertificate)(unsafe.Pointer(cert.Native())), chost, ccaFile, &err)
+	if err != nil {
+		return false, gerror(err)
+	}
+	return ok != C.FALSE, nil
+}
+
+// allowTLSCertificate makes v's web context accept the GTlsCertificate cert
+// for host.
+func allowTLSCertificate(v *webkit2.WebView, cert *glib.Object, host string) {
+	chost := C.CString(host)
+	defer C.free(unsafe.Pointer(chost))
+	C.webkit_web_context_allow_tls_certificate_for_host(C.webkit_web_view_get_context(webViewPtr(v)), (*C.GTlsCertificate)(unsafe.Pointer(cert.Native())), (*C.gchar)(chost))
+}
+
+// setProxySettings makes the web context of v (and of all other views that
+// share it) use the proxies in p.
+func setProxySettings(v *webkit2.WebView, p *ProxySettings) {
+	ctx := C.webkit_web_view_get_context(webViewPtr(v))
+	if p.direct() {
+		C.webkit_web_context_set_network_proxy_settings(ctx, C.WEBKIT_NETWORK_PROXY_MODE_NO_PROXY, nil)
+		return
+	}
+
+	// ignore_hosts is a NULL-terminated array of strings.
+	bypass := make([]*C.gchar, len(p.Bypass)+1)
+	for i, host := range p.Bypass {
+		bypass[i] = (*C.gchar)(C.CString(host))
+		defer C.free(unsafe.Pointer(bypass[i]))
+	}
+	var socks *C.gchar
+	if p.SOCKS != "" {
+		socks = (*C.gchar)(C.CString(p.SOCKS))
+		defer C.free(unsafe.Pointer(socks))
+	}
+	settings := C.webkit_network_proxy_settings_new(socks, &bypass[0])
+	defer C.webkit_network_proxy_settings_free(settings)
+	for scheme, uri := range map[string]string{"http": p.HTTP, "https": p.HTTPS} {
+		if uri == "" {
+			continue
+		}
+		cscheme, curi := C.CString(scheme), C.CString(uri)
+		C.webkit_network_proxy_settings_add_proxy_for_scheme(settings, (*C.gchar)(cscheme), (*C.gchar)(curi))
+		C.free(unsafe.Pointer(cscheme))
+		C.free(unsafe.Pointer(curi))
+	}
+	C.webkit_web_context_set_network_proxy_settings(ctx, C.WEBKIT_NETWORK_PROXY_MODE_CUSTOM, settings)
+}
+
+// mainResourceHeader returns the values of the named header in the response
+// for the main resource of the page loaded in v, or nil if there is no such
+// response or header.
+func mainResourceHeader(v *webkit2.WebView, name string) []string {
+	res := C.webkit_web_view_get_main_resource(webViewPtr(v))
+	if res == nil {
+		return nil
+	}
+	resp := C.webkit_web_resource_get_response(res)
+	if resp == nil {
+		return nil
+	}
+	h := C.webkit_uri_response_get_http_headers(resp)
+	if h == nil {
+		return nil
+	}
+	cname := C.CString(name)
+	defer C.free(unsafe.Pointer(cname))
+	list := C.soup_message_headers_get_list(h, cname)
+	if list == nil {
+		return nil
+	}
+	return []string{C.GoString(list)}
+}
+
+// asyncData is the result of an asynchronous WebKit operation that returns
+// data, such as saveMHTML. Its methods must be called on the GTK+ thread.
+type asyncData struct {
+	r *C.async_data
+}
+
+// done reports whether the operation has finished.
+func (d *asyncData) done() bool {
+	return d.r.done != C.FALSE
+}
+
+// result returns the operation's data or error and frees d. It must only be
+// called after done returns true.
+func (d *asyncData) result() ([]byte, error) {
+	defer C.g_free(C.gpointer(d.r))
+	if d.r.error != nil {
+		return nil, gerror(d.r.error)
+	}
+	defer C.g_free(C.gpointer(d.r.data))
+	return C.GoBytes(unsafe.Pointer(d.r.data), C.int(d.r.length)), nil
+}
+
+// saveMHTML starts saving the page loaded in v as MHTML.
+func saveMHTML(v *webkit2.WebView) *asyncData {
+	return &asyncData{C.save_mhtml(webViewPtr(v))}
+}
+
+func webResourcePtr(resource *glib.Object) *C.WebKitWebResource {
+	return (*C.WebKitWebResource)(unsafe.Pointer(resource.Native()))
+}
+
+// resourceData starts getting the data of the WebKitWebResource resource.
+func resourceData(resource *glib.Object) *asyncData {
+	return &asyncData{C.get_resource_data(webResourcePtr(resource))}
+}
+
+// resourceMIMEType returns the MIME type of the response for the
+// WebKitWebResource resource, or "" if it has no response.
+func resourceMIMEType(resource *glib.Object) string {
+	resp := C.webkit_web_resource_get_response(webResourcePtr(resource))
+	if resp == nil {
+		return ""
+	}
+	return C.GoString((*C.char)(C.webkit_uri_response_get_mime_type(resp)))
+}
+
+// terminateWebProcess terminates v's web process, as if it had crashed.
+// It requires WebKitGTK+ 2.34 or newer.
+func terminateWebProcess(v *webkit2.WebView) {
+	C.webkit_web_view_terminate_web_process(webViewPtr(v))
+}
+
+// setUserScript makes v run the JavaScript source at the start of each page
+// (and frame) that it subsequently loads, replacing any previous script. If
+// source is empty, no script is run.
+func setUserScript(v *webkit2.WebView, source string) {
+	ucm := C.webkit_web_view_get_user_content_manager(webViewPtr(v))
+	C.webkit_user_content_manager_remove_all_scripts(ucm)
+	if source == "" {
+		return
+	}
+	csource := C.CString(source)
+	defer C.free(unsafe.Pointer(csource))
+	script := C.webkit_user_script_new((*C.gchar)(csource), C.WEBKIT_USER_CONTENT_INJECT_ALL_FRAMES, C.WEBKIT_USER_SCRIPT_INJECT_AT_DOCUMENT_START, nil, nil)
+	C.webkit_user_content_manager_add_script(ucm, script)
+	C.webkit_user_script_unref(script)
+}
+
+// webViewID returns an identifier for v that is unique among existing web
+// views.
+func webViewID(v *webkit2.WebView) uintptr {
+	return uintptr(unsafe.Pointer(webViewPtr(v)))
+}
+
+// webContext returns the WebKitWebContext of v.
+func webContext(v *webkit2.WebView) *glib.Object {
+	return glib.Take(unsafe.Pointer(C.webkit_web_view_get_context(webViewPtr(v))))
+}
+
+// policyDecisionTypeResponse is the WebKitPolicyDecisionType of decisions
+// about what to do with a response.
+var policyDecisionTypeResponse = int(C.WEBKIT_POLICY_DECISION_TYPE_RESPONSE)
+
+// isDownloadResponse reports whether the response in the
+// WebKitResponsePolicyDecision decision should be downloaded, because WebKit
+// can't display its MIME type or it is sent as an attachment.
+func isDownloadResponse(decision *glib.Object) bool {
+	return C.is_download_response((*C.WebKitResponsePolicyDecision)(unsafe.Pointer(decision.Native()))) != C.FALSE
+}
+
+// downloadResponse makes WebKit download the response in the
+// WebKitResponsePolicyDecision decision.
+func downloadResponse(decision *glib.Object) {
+	C.webkit_policy_decision_download((*C.WebKitPolicyDecision)(unsafe.Pointer(decision.Native())))
+}
+
+func downloadPtr(download *glib.Object) *C.WebKitDownload {
+	return (*C.WebKitDownload)(unsafe.Pointer(download.Native()))
+}
+
+// downloadWebViewID returns the webViewID of the web view that started the
+// WebKitDownload download, or 0 if none did.
+func downloadWebViewID(download *glib.Object) uintptr {
+	return uintptr(unsafe.Pointer(C.webkit_download_get_web_view(downloadPtr(download))))
+}
+
+// downloadInfo returns the URI of the WebKitDownload download and, once its
+// response has been received, the MIME type and size (or 0 if unknown) of the
+// file.
+func downloadInfo(download *glib.Object) (uri, mimeType string, size int64) {
+	d := downloadPtr(download)
+	if req := C.webkit_download_get_request(d); req != nil {
+		uri = C.GoString((*C.char)(C.webkit_uri_request_get_uri(req)))
+	}
+	if resp := C.webkit_download_get_response(d); resp != nil {
+		mimeType = C.GoString((*C.char)(C.webkit_uri_response_get_mime_type(resp)))
+		size = int64(C.webkit_uri_response_get_content_length(resp))
+	}
+	return uri, mimeType, size
+}
+
+// setDownloadDestination makes the WebKitDownload download save the file to
+// path, replacing any existing file if overwrite is true.
+func setDownloadDestination(download *glib.Object, path string, overwrite bool) {
+	d := downloadPtr(download)
+	var allow C.gboolean
+	if overwrite {
+		allow = 1
+	}
+	C.webkit_download_set_allow_overwrite(d, allow)
+	curi := C.CString((&url.URL{Scheme: "file", Path: path}).String())
+	defer C.free(unsafe.Pointer(curi))
+	C.webkit_download_set_destination(d, (*C.gchar)(curi))
+}
+
+func cancelDownload(download *glib.Object) {
+	C.webkit_download_cancel(downloadPtr(download))
+}
+
+// downloadProgress returns the estimated progress of the WebKitDownload
+// download, from 0 to 1.
+func downloadProgress(download *glib.Object) float64 {
+	return float64(C.webkit_download_get_estimated_progress(downloadPtr(download)))
+}
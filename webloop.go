@@ -2,6 +2,9 @@ package webloop
 
 import (
 	"errors"
+	"net/http"
+	"sync"
+	"time"
 
 	"github.com/gotk3/gotk3/glib"
 	"github.com/sourcegraph/go-webkit2/webkit2"
@@ -11,8 +14,67 @@ import (
 // ErrLoadFailed indicates that the View failed to load the requested resource.
 var ErrLoadFailed = errors.New("load failed")
 
+// ErrWebProcessCrashed indicates that the View's WebKit web process crashed or
+// was killed (for example, because it ran out of memory). The View is no
+// longer usable and should be closed and replaced.
+var ErrWebProcessCrashed = errors.New("web process crashed")
+
+// ErrLoadSuperseded indicates that a page load was superseded by another load
+// in the same View before it finished.
+var ErrLoadSuperseded = errors.New("load superseded by another load")
+
+// ErrViewClosed indicates that the View was closed before (or while) the
+// operation ran.
+var ErrViewClosed = errors.New("view closed")
+
 // Context stores common settings for a group of Views.
-type Context struct{}
+type Context struct {
+	// Metrics receives measurements of the Views' web processes and of
+	// StaticRenderers that use this Context. If nil, no measurements are
+	// recorded.
+	Metrics Metrics
+
+	// Credentials are used to authenticate with servers that require HTTP
+	// (basic or digest) authentication, keyed by "host" or "host:port".
+	Credentials map[string]Credentials
+
+	// ClientCertFile and ClientKeyFile are PEM files containing the TLS
+	// client certificate (and its private key) to present to servers that
+	// request one. ClientKeyFile may be empty if ClientCertFile contains
+	// the key. Client certificates require WebKitGTK+ 2.34 or newer.
+	ClientCertFile, ClientKeyFile string
+
+	// CAFile is a PEM file of additional certificate authorities to trust
+	// for pages' main resources.
+	CAFile string
+
+	// InsecureHosts are the hosts whose TLS certificates are accepted for
+	// pages' main resources even if they fail verification.
+	InsecureHosts []string
+
+	// Proxy configures the proxies that views use. If nil, the proxies in
+	// the HTTP_PROXY, HTTPS_PROXY, ALL_PROXY and NO_PROXY environment
+	// variables are used; if those aren't set either, WebKit uses the
+	// system proxy settings. To connect directly even if those variables
+	// are set, use an empty ProxySettings.
+	//
+	// All views in a process share WebKit's network session, so the
+	// proxy settings of the Context that creates the process's first View
+	// apply to all views, and those of other Contexts are ignored.
+	Proxy *ProxySettings
+
+	// OnDownload, if non-nil, is called when a page in one of the
+	// context's views starts downloading a file (such as a CSV export or a
+	// link to a ZIP file), and returns what to do with it. If nil,
+	// downloads are rejected. It is called on the GTK+ thread, so it must
+	// not block or call View methods.
+	OnDownload func(*Download) DownloadPolicy
+
+	// DownloadDir is the directory in which downloads are saved with
+	// DownloadToDirectory. If empty, the system's temporary directory is
+	// used.
+	DownloadDir string
+}
 
 // New creates a new Context.
 func New() *Context {
@@ -21,30 +83,83 @@ func New() *Context {
 
 // NewView creates a new View in the context.
 func (c *Context) NewView() *View {
-	view := make(chan *View, 1)
-	glib.IdleAdd(func() bool {
+	var v *View
+	mustDo(func() {
 		webView := webkit2.NewWebView()
 		settings := webView.Settings()
 		settings.SetEnableWriteConsoleMessagesToStdout(true)
 		settings.SetUserAgentWithApplicationDetails("WebLoop", "v1")
-		v := &View{WebView: webView}
-		loadChangedHandler, _ := webView.Connect("load-changed", func(_ *glib.Object, loadEvent webkit2.LoadEvent) {
+		proxyOnce.Do(func() {
+			if proxy := c.proxySettings(); proxy != nil {
+				setProxySettings(webView, proxy)
+			}
+		})
+		v = &View{
+			WebView: webView,
+			crashed: make(chan struct{}),
+			closed:  make(chan struct{}),
+			changed: make(chan struct{}),
+			sources: map[glib.SourceHandle]struct{}{},
+			created: time.Now(),
+
+			onDownload:  c.OnDownload,
+			downloadDir: c.DownloadDir,
+		}
+		webView.Connect("load-changed", func(_ *glib.Object, loadEvent webkit2.LoadEvent) {
 			switch loadEvent {
+			case webkit2.LoadStarted:
+				v.loadStarted()
+				v.sendEvent(Event{Type: EventLoadStarted})
+			case webkit2.LoadRedirected:
+				v.sendEvent(Event{Type: EventLoadRedirected})
+			case webkit2.LoadCommitted:
+				v.resources = nil
+				v.pageCommitted()
+				v.sendEvent(Event{Type: EventLoadCommitted})
 			case webkit2.LoadFinished:
-				// If we're here, then the load must not have failed, because
-				// otherwise we would've disconnected this handler in the
-				// load-failed signal handler.
-				v.load <- struct{}{}
+				// If the load failed, it already finished in the
+				// load-failed signal handler, and this does nothing.
+				v.finishLoad(nil)
+				v.sendEvent(Event{Type: EventLoadFinished})
 			}
 		})
-		webView.Connect("load-failed", func() {
-			v.lastLoadErr = ErrLoadFailed
-			webView.HandlerDisconnect(loadChangedHandler)
+		webView.Connect("load-failed", func(_ *glib.Object, _ webkit2.LoadEvent, _ string, err uintptr) {
+			v.loadFailed(isCancelledError(err))
+			v.sendEvent(Event{Type: EventLoadFailed, Err: ErrLoadFailed})
 		})
-		view <- v
-		return false
+		v.connectEvents()
+		v.connectDownloads()
+		addOpenViews(1)
+		webView.Connect("authenticate", func(_ *glib.Object, req *glib.Object) bool {
+			return c.authenticate(req)
+		})
+		webView.Connect("load-failed-with-tls-errors", func(_ *glib.Object, uri string, cert *glib.Object) bool {
+			return c.tlsErrors(v, uri, cert)
+		})
+		webView.Connect("resource-load-started", func(_ *glib.Object, resource *glib.Object) {
+			v.resourceLoadStarted(resource)
+		})
+		metrics := c.metrics()
+		webProcessTerminated := func() {
+			v.crashOnce.Do(func() {
+				metrics.CountWebProcessTermination()
+				close(v.crashed)
+			})
+		}
+		// WebKitGTK+ >= 2.20 emits web-process-terminated (and, for crashes,
+		// also the deprecated web-process-crashed); older versions only
+		// emit web-process-crashed.
+		webView.Connect("web-process-terminated", webProcessTerminated)
+		webView.Connect("web-process-crashed", webProcessTerminated)
 	})
-	return <-view
+	return v
+}
+
+func (c *Context) metrics() Metrics {
+	if c.Metrics == nil {
+		return nopMetrics{}
+	}
+	return c.Metrics
 }
 
 // View represents a WebKit view that can load resources at a given URL and
@@ -52,101 +167,409 @@ func (c *Context) NewView() *View {
 type View struct {
 	*webkit2.WebView
 
-	load        chan struct{}
-	lastLoadErr error
+	crashed   chan struct{} // closed when the web process terminates
+	crashOnce sync.Once
 
-	destroyed bool
+	closed    chan struct{} // closed when Close is called
+	closeOnce sync.Once
+	sources   map[glib.SourceHandle]struct{} // idle sources added by do that haven't run, or nil after Close; guarded by mu
+
+	// resources are the resources that the current page has loaded, for
+	// SaveArchive. It is only accessed on the GTK+ thread.
+	resources []pageResource
+
+	onDownload  func(*Download) DownloadPolicy // called on the GTK+ thread
+	downloadDir string
+
+	mu               sync.Mutex
+	load             *pageLoad  // the most recently started load
+	events           chan Event // nil until Events is called
+	eventsClosed     bool
+	resourceObserver func(Resource) // called on the GTK+ thread
+	loads            int
+	created          time.Time
+
+	// State for the WaitFor methods, guarded by mu. The changed channel is
+	// closed (and replaced) when the state changes.
+	changed        chan struct{}
+	navs           int    // number of times the URI has changed
+	uri            string // the current URI
+	loading        bool   // whether a page is loading
+	page           int    // number of pages committed, to tell which page resources belong to
+	inflight       int    // number of resources of the current page being loaded
+	networkChanged time.Time
+	downloads      []*Download // not yet returned by WaitForDownload, oldest first
 }
 
-// Open starts loading the resource at the specified URL.
-func (v *View) Open(url string) {
-	v.load = make(chan struct{}, 1)
-	v.lastLoadErr = nil
-	glib.IdleAdd(func() bool {
-		if !v.destroyed {
-			v.WebView.LoadURI(url)
+// Resource describes a resource (such as the page itself, a script, an image
+// or an XMLHttpRequest) that a View loaded.
+type Resource struct {
+	// URI is the URI of the resource.
+	URI string
+
+	// Started and Finished are when the View started and finished loading
+	// the resource.
+	Started, Finished time.Time
+
+	// Err is non-nil if the resource failed to load.
+	Err error
+}
+
+// resourceLoadStarted is called on the GTK+ thread when the view starts
+// loading a WebKitWebResource.
+func (v *View) resourceLoadStarted(resource *glib.Object) {
+	res := Resource{Started: time.Now()}
+	page := v.resourceStarted()
+	if uri, err := resource.GetProperty("uri"); err == nil {
+		res.URI, _ = uri.(string)
+	}
+	if len(v.resources) < maxArchiveResources {
+		v.resources = append(v.resources, pageResource{resource, res.URI})
+	}
+
+	// WebKit emits "failed" (if the load failed) and then "finished".
+	failed, _ := resource.Connect("failed", func() {
+		res.Err = ErrLoadFailed
+	})
+	var finished glib.SignalHandle
+	finished, _ = resource.Connect("finished", func() {
+		resource.HandlerDisconnect(failed)
+		resource.HandlerDisconnect(finished)
+		res.Finished = time.Now()
+		v.resourceFinished(page)
+
+		v.mu.Lock()
+		observe := v.resourceObserver
+		v.mu.Unlock()
+		if observe != nil {
+			observe(res)
 		}
-		return false
 	})
 }
 
+// setResourceObserver sets a func that is called (on the GTK+ thread) each
+// time the view finishes loading a resource. If observe is nil, the current
+// observer is removed.
+func (v *View) setResourceObserver(observe func(Resource)) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.resourceObserver = observe
+}
+
+// Open starts loading the resource at the specified URL. If a previous load
+// hasn't finished, it is superseded, and Wait calls waiting for it return
+// ErrLoadSuperseded.
+func (v *View) Open(url string) {
+	v.startLoad(nil, func() { v.WebView.LoadURI(url) })
+}
+
+// OpenWithHeader starts loading the resource at the specified URL, adding the
+// headers in header to the request for it. The headers are not sent in the
+// requests for subresources (such as scripts, images and XMLHttpRequests).
+func (v *View) OpenWithHeader(url string, header http.Header) {
+	v.startLoad(header, func() { loadURIWithHeader(v.WebView, url, header) })
+}
+
 func (v *View) Load(content, baseUrl string) {
-	v.load = make(chan struct{}, 1)
-	v.lastLoadErr = nil
-	glib.IdleAdd(func() bool {
-		if !v.destroyed {
-			v.WebView.LoadHTML(content, baseUrl)
-		}
-		return false
-	})
+	v.startLoad(nil, func() { v.WebView.LoadHTML(content, baseUrl) })
+}
+
+// Back starts loading the previous page in the view's history. If there is no
+// previous page, it returns false and does nothing. If the previous page is
+// the same document (for example, an entry added by history.pushState), no page
+// is loaded, and Wait returns as soon as WebKit has moved to it.
+func (v *View) Back() bool {
+	var ok bool
+	v.do(func() { ok = canGoBack(v.WebView) })
+	if ok {
+		v.startLoad(nil, func() { goBack(v.WebView) })
+	}
+	return ok
+}
+
+// Forward starts loading the next page in the view's history. If there is no
+// next page, it returns false and does nothing. As with Back, Wait returns
+// without a page load if the next page is the same document.
+func (v *View) Forward() bool {
+	var ok bool
+	v.do(func() { ok = canGoForward(v.WebView) })
+	if ok {
+		v.startLoad(nil, func() { goForward(v.WebView) })
+	}
+	return ok
+}
+
+// Reload starts reloading the current page. If bypassCache is true, the page's
+// resources are reloaded from the network even if they are cached.
+func (v *View) Reload(bypassCache bool) {
+	v.startLoad(nil, func() { reload(v.WebView, bypassCache) })
+}
+
+// Stop stops loading the current page. Wait calls waiting for it return
+// ErrLoadFailed.
+func (v *View) Stop() {
+	v.mu.Lock()
+	if v.load != nil {
+		v.load.finish(ErrLoadFailed)
+	}
+	v.mu.Unlock()
+	v.do(func() { stopLoading(v.WebView) })
 }
 
-// Wait waits for the current page to finish loading.
+// Wait waits for the current page to finish loading. If the view's web
+// process crashes, it returns ErrWebProcessCrashed. If no page has been
+// loaded, it returns nil immediately.
 func (v *View) Wait() error {
-	<-v.load
-	return v.lastLoadErr
+	v.mu.Lock()
+	l := v.load
+	v.mu.Unlock()
+	if l == nil {
+		return nil
+	}
+
+	select {
+	case <-l.done:
+		return l.err
+	case <-v.crashed:
+		return ErrWebProcessCrashed
+	case <-v.closed:
+		return ErrViewClosed
+	}
 }
 
-// URI returns the URI of the current resource in the view.
-func (v *View) URI() string {
-	uri := make(chan string, 1)
-	glib.IdleAdd(func() bool {
-		uri <- v.WebView.URI()
-		return false
+// pageLoad is the state of a page load started by Open, OpenWithHeader or
+// Load. Its fields are guarded by the View's mu.
+type pageLoad struct {
+	// requested is whether the load has been requested from WebKit, and
+	// started is whether WebKit has since started it. Events before then
+	// belong to the load that this load superseded.
+	requested, started bool
+
+	header http.Header // the headers that the load was opened with
+
+	done chan struct{} // closed when the load finishes
+	err  error         // the load's error, set before done is closed
+}
+
+// startLoad supersedes the current load (if any) with a new load, which
+// start starts on the GTK+ thread with the headers in header.
+func (v *View) startLoad(header http.Header, start func()) {
+	l := v.supersedeLoad(header)
+	v.do(func() {
+		v.mu.Lock()
+		l.requested = true
+		v.mu.Unlock()
+		start()
 	})
-	return <-uri
 }
 
-// Title returns the title of the current resource in the view.
-func (v *View) Title() string {
-	title := make(chan string, 1)
-	glib.IdleAdd(func() bool {
-		title <- v.WebView.Title()
+// supersedeLoad finishes the current load (if any) with ErrLoadSuperseded and
+// replaces it with a new load with the headers in header, which the caller
+// must request from WebKit.
+func (v *View) supersedeLoad(header http.Header) *pageLoad {
+	l := &pageLoad{header: header, done: make(chan struct{})}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.loads++
+	if v.load != nil {
+		v.load.finish(ErrLoadSuperseded)
+	}
+	v.load = l
+	return l
+}
+
+// loadStarted is called on the GTK+ thread when WebKit starts a load. A load
+// that WebKit starts before the current load is requested (such as the
+// superseded load, if it was requested just before) is not the current load.
+func (v *View) loadStarted() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.load != nil && v.load.requested {
+		v.load.started = true
+	}
+}
+
+// loadFailed is called on the GTK+ thread when WebKit fails a load. Loads are
+// only cancelled when they are superseded or stopped, which finishes them
+// already, so a cancellation is ignored: it may belong to the superseded load
+// even after the current load has started.
+func (v *View) loadFailed(cancelled bool) {
+	if !cancelled {
+		v.finishLoad(ErrLoadFailed)
+	}
+}
+
+// finishLoad is called on the GTK+ thread when WebKit finishes (if err is nil)
+// or fails a load.
+func (v *View) finishLoad(err error) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.load != nil && v.load.started {
+		v.load.finish(err)
+	}
+}
+
+// retryLoad is called on the GTK+ thread to load uri again after the current
+// load failed to load it (for example, to retry it with an allowed TLS
+// certificate). The request has the headers that the load was opened with, and
+// events from the failed load don't finish the load.
+func (v *View) retryLoad(uri string) {
+	v.mu.Lock()
+	var header http.Header
+	if v.load != nil {
+		v.load.started = false
+		header = v.load.header
+	}
+	v.mu.Unlock()
+	loadURIWithHeader(v.WebView, uri, header)
+}
+
+// finish finishes l with err, unless it has already finished. The View's mu
+// must be held.
+func (l *pageLoad) finish(err error) {
+	select {
+	case <-l.done:
+	default:
+		l.err = err
+		close(l.done)
+	}
+}
+
+// Crashed reports whether the view's web process has crashed, making the view
+// unusable.
+func (v *View) Crashed() bool {
+	select {
+	case <-v.crashed:
+		return true
+	default:
 		return false
-	})
-	return <-title
+	}
+}
+
+// URI returns the URI of the current resource in the view.
+func (v *View) URI() (uri string) {
+	v.do(func() { uri = v.WebView.URI() })
+	return uri
+}
+
+// Title returns the title of the current resource in the view.
+func (v *View) Title() (title string) {
+	v.do(func() { title = v.WebView.Title() })
+	return title
 }
 
 // EvaluateJavaScript runs the JavaScript in script in the view's context and
-// returns the script's result as a Go value.
+// returns the script's result as a Go value. If the view's web process
+// crashes, it returns ErrWebProcessCrashed.
 func (v *View) EvaluateJavaScript(script string) (result interface{}, err error) {
 	resultChan := make(chan interface{}, 1)
 	errChan := make(chan error, 1)
 
-	glib.IdleAdd(func() bool {
+	err = v.do(func() {
 		v.WebView.RunJavaScript(script, func(result *gojs.Value, err error) {
-			glib.IdleAdd(func() bool {
-				if err == nil {
-					goval, err := result.GoValue()
-					if err != nil {
-						errChan <- err
-						return false
-					}
-					resultChan <- goval
-				} else {
-					errChan <- err
-				}
-				return false
-			})
+			if err != nil {
+				errChan <- err
+				return
+			}
+			goval, err := result.GoValue()
+			if err != nil {
+				errChan <- err
+				return
+			}
+			resultChan <- goval
 		})
-		return false
 	})
+	if err != nil {
+		return nil, err
+	}
 
 	select {
 	case result = <-resultChan:
 		return result, nil
 	case err = <-errChan:
 		return nil, err
+	case <-v.crashed:
+		return nil, ErrWebProcessCrashed
+	case <-v.closed:
+		return nil, ErrViewClosed
 	}
 }
 
-// Close closes the view and releases associated resources. Ensure that Close is
-// called after all other pending operations on View have returned, or they may
-// hang indefinitely.
+// Close closes the view and releases associated resources. Pending
+// operations on the view are canceled, and calls to Wait and
+// EvaluateJavaScript that are in progress return ErrViewClosed. Close may be
+// called multiple times and concurrently.
 func (v *View) Close() {
-	// TODO(sqs): remove all of the source funcs we added via IdleAdd, etc.,
-	// using g_source_remove, to fix "assertion
-	// 'WEBKIT_IS_WEB_VIEW(webView) failed" messages.
-	v.destroyed = true
-	v.Destroy()
+	v.closeOnce.Do(func() {
+		v.mu.Lock()
+		sources := v.sources
+		v.sources = nil
+		v.mu.Unlock()
+		close(v.closed)
+		v.closeEvents()
+
+		mustDo(func() {
+			for source := range sources {
+				removeSource(source)
+			}
+			addOpenViews(-1)
+			delete(downloadViews, webViewID(v.WebView))
+			v.Destroy()
+		})
+	})
+}
+
+// do runs f on the GTK+ main loop thread and waits for it to return, like Do.
+// If the view is closed before f runs, f is not run and do returns
+// ErrViewClosed. If f panics, do panics in the calling goroutine.
+func (v *View) do(f func()) error {
+	if onMainLoopThread() {
+		if v.isClosed() {
+			return ErrViewClosed
+		}
+		f()
+		return nil
+	}
+
+	Start()
+	done := make(chan error, 1)
+	v.mu.Lock()
+	if v.sources == nil {
+		v.mu.Unlock()
+		return ErrViewClosed
+	}
+	// The source can't run until v.mu is unlocked, so source is set by then.
+	var source glib.SourceHandle
+	source, _ = glib.IdleAdd(func() bool {
+		v.mu.Lock()
+		delete(v.sources, source)
+		v.mu.Unlock()
+		if v.isClosed() {
+			done <- ErrViewClosed
+		} else {
+			done <- call(f)
+		}
+		return false
+	})
+	v.sources[source] = struct{}{}
+	v.mu.Unlock()
+
+	select {
+	case err := <-done:
+		if err != nil && err != ErrViewClosed {
+			panic(err)
+		}
+		return err
+	case <-v.closed:
+		return ErrViewClosed
+	}
+}
+
+func (v *View) isClosed() bool {
+	select {
+	case <-v.closed:
+		return true
+	default:
+		return false
+	}
 }
@@ -5,19 +5,11 @@ import (
 	"net/http"
 	"reflect"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
-
-	"github.com/gotk3/gotk3/gtk"
 )
 
-func init() {
-	gtk.Init(nil)
-	go func() {
-		runtime.LockOSThread()
-		gtk.Main()
-	}()
-}
-
 var ctx Context
 
 func TestNew(t *testing.T) {
@@ -51,10 +43,10 @@ func TestView_Wait(t *testing.T) {
 	view := ctx.NewView()
 	defer view.Close()
 
-	loaded := false
+	var loaded int32
 	mux.HandleFunc("/abc", func(w http.ResponseWriter, _ *http.Request) {
 		w.Write([]byte("abc"))
-		loaded = true
+		atomic.StoreInt32(&loaded, 1)
 	})
 
 	url := server.URL + "/abc"
@@ -65,11 +57,50 @@ func TestView_Wait(t *testing.T) {
 		t.Errorf("want URI %q, got %q", url, gotURI)
 	}
 
-	if !loaded {
+	if atomic.LoadInt32(&loaded) == 0 {
 		t.Error("!loaded")
 	}
 }
 
+func TestView_Close(t *testing.T) {
+	setup()
+	defer teardown()
+
+	// Never respond, so that Wait blocks until the view is closed.
+	block := make(chan struct{})
+	defer close(block)
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {
+		<-block
+	})
+
+	view := ctx.NewView()
+	view.Open(server.URL)
+
+	waitErr := make(chan error)
+	go func() {
+		waitErr <- view.Wait()
+	}()
+
+	// Close concurrently and more than once.
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			view.Close()
+		}()
+	}
+	wg.Wait()
+	view.Close()
+
+	if err := <-waitErr; err != ErrViewClosed {
+		t.Errorf("Wait: want ErrViewClosed, got %v", err)
+	}
+	if _, err := view.EvaluateJavaScript("1"); err != ErrViewClosed {
+		t.Errorf("EvaluateJavaScript after Close: want ErrViewClosed, got %v", err)
+	}
+}
+
 func TestView_Wait_multi(t *testing.T) {
 	runtime.LockOSThread()
 
@@ -79,14 +110,14 @@ func TestView_Wait_multi(t *testing.T) {
 	view := ctx.NewView()
 	defer view.Close()
 
-	loaded := 0
+	var loaded int32
 	mux.HandleFunc("/abc", func(w http.ResponseWriter, _ *http.Request) {
 		w.Write([]byte("abc"))
-		loaded++
+		atomic.AddInt32(&loaded, 1)
 	})
 	mux.HandleFunc("/xyz", func(w http.ResponseWriter, _ *http.Request) {
 		w.Write([]byte("xyz"))
-		loaded++
+		atomic.AddInt32(&loaded, 1)
 	})
 
 	url1 := server.URL + "/abc"
@@ -104,8 +135,141 @@ func TestView_Wait_multi(t *testing.T) {
 		t.Errorf("want URI %q, got %q", url2, gotURI)
 	}
 
-	if wantLoaded := 2; wantLoaded != loaded {
-		t.Errorf("want loaded == %d, got %d", wantLoaded, loaded)
+	if wantLoaded := int32(2); wantLoaded != atomic.LoadInt32(&loaded) {
+		t.Errorf("want loaded == %d, got %d", wantLoaded, atomic.LoadInt32(&loaded))
+	}
+}
+
+func TestView_Wait_superseded(t *testing.T) {
+	setup()
+	defer teardown()
+
+	block := make(chan struct{})
+	defer close(block)
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, _ *http.Request) {
+		<-block
+	})
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte("fast"))
+	})
+
+	view := ctx.NewView()
+	defer view.Close()
+
+	view.Open(server.URL + "/slow")
+	// Wait waits for whichever load is current when it is called, so the
+	// first load is captured before the second Open supersedes it.
+	view.mu.Lock()
+	first := view.load
+	view.mu.Unlock()
+
+	view.Open(server.URL + "/fast")
+	<-first.done
+	if first.err != ErrLoadSuperseded {
+		t.Errorf("first load: want ErrLoadSuperseded, got %v", first.err)
+	}
+	if err := view.Wait(); err != nil {
+		t.Errorf("second Wait: %s", err)
+	}
+	if want, got := server.URL+"/fast", view.URI(); want != got {
+		t.Errorf("want URI %q, got %q", want, got)
+	}
+}
+
+// TestView_loadEvents_superseded checks that WebKit's events for a superseded
+// load, which may arrive after the load that superseded it is requested, don't
+// finish that load.
+func TestView_loadEvents_superseded(t *testing.T) {
+	tests := map[string]func(v *View, requestB func()){
+		"first load starts late and is cancelled": func(v *View, requestB func()) {
+			v.loadStarted()
+			requestB()
+			v.loadFailed(true)
+		},
+		"first load starts late and finishes": func(v *View, requestB func()) {
+			v.loadStarted()
+			v.finishLoad(nil)
+			requestB()
+		},
+		"first load is cancelled after the second starts": func(v *View, requestB func()) {
+			requestB()
+			v.loadStarted()
+			v.loadFailed(true)
+		},
+	}
+	for name, events := range tests {
+		v := &View{}
+		a := v.supersedeLoad(nil)
+		a.requested = true
+		b := v.supersedeLoad(nil)
+		events(v, func() { b.requested = true })
+		select {
+		case <-b.done:
+			t.Errorf("%s: second load finished early with %v", name, b.err)
+			continue
+		default:
+		}
+		v.loadStarted()
+		v.finishLoad(nil)
+		if a.err != ErrLoadSuperseded {
+			t.Errorf("%s: first load: want ErrLoadSuperseded, got %v", name, a.err)
+		}
+		<-b.done
+		if b.err != nil {
+			t.Errorf("%s: second load: %s", name, b.err)
+		}
+	}
+}
+
+func TestView_loadingChanged(t *testing.T) {
+	tests := []struct {
+		name               string
+		requested, started bool
+		wantFinished       bool
+	}{
+		{name: "same-document move", requested: true, wantFinished: true},
+		{name: "load not yet requested"},
+		{name: "load started", requested: true, started: true},
+	}
+	for _, test := range tests {
+		v := &View{changed: make(chan struct{})}
+		l := v.supersedeLoad(nil)
+		l.requested, l.started = test.requested, test.started
+		v.loadingChanged(true)
+		v.loadingChanged(false)
+		select {
+		case <-l.done:
+			if !test.wantFinished {
+				t.Errorf("%s: load finished with %v", test.name, l.err)
+			} else if l.err != nil {
+				t.Errorf("%s: %s", test.name, l.err)
+			}
+		default:
+			if test.wantFinished {
+				t.Errorf("%s: load not finished", test.name)
+			}
+		}
+	}
+}
+
+func TestView_Wait_afterLoadFailed(t *testing.T) {
+	setup()
+	defer teardown()
+	mux.HandleFunc("/", func(w http.ResponseWriter, _ *http.Request) {})
+
+	view := ctx.NewView()
+	defer view.Close()
+
+	// Nothing listens on port 1.
+	view.Open("http://127.0.0.1:1/")
+	if err := view.Wait(); err != ErrLoadFailed {
+		t.Errorf("want ErrLoadFailed, got %v", err)
+	}
+
+	// Later loads in the view should still succeed.
+	view.Open(server.URL)
+	if err := view.Wait(); err != nil {
+		t.Errorf("after failed load: %s", err)
 	}
 }
 